@@ -99,6 +99,8 @@ func NewMyStack(scope constructs.Construct, id string) cdktf.TerraformStack {
 		Environment: &map[string]*string{
 			"AWS_ACCESS_KEY_ID":     staticAccessKey.AccessKey(),
 			"AWS_SECRET_ACCESS_KEY": staticAccessKey.SecretKey(),
+			"SEARCH_BUCKET":         j.String("sls-search"),
+			"SEARCH_KEY":            j.String("film/index.tar.zst"),
 		},
 		Tags: j.Strings("zst"),
 		//DependsOn: &[]cdktf.ITerraformDependable{index},
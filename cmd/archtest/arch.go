@@ -7,8 +7,7 @@ import (
 	"fmt"
 	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archiver/v4"
-	"io"
-	"io/fs"
+	"github.com/nikolaymatrosov/go-sls-search/extract"
 	"log"
 	"os"
 	"path"
@@ -70,7 +69,7 @@ func main() {
 
 	for i := 0; i < 10; i++ {
 		for _, filename := range files {
-			extract(filename, res)
+			extractFile(filename, res)
 		}
 	}
 	printRes(res)
@@ -164,16 +163,12 @@ func archiveZip(filename string, res map[string][]string) {
 	}
 }
 
-func extract(filename string, res map[string][]string) {
+func extractFile(filename string, res map[string][]string) {
 	inp, err := os.Open(filename)
 	if err != nil {
 		log.Fatalf("failed to open file: %s", err)
 	}
-	format, input, err := archiver.Identify(filename, inp)
-	if err != nil {
-		log.Fatalf("unsupported archive type for file: %s", err)
-		return
-	}
+	defer inp.Close()
 	start := time.Now()
 
 	defer func() {
@@ -182,52 +177,9 @@ func extract(filename string, res map[string][]string) {
 		res[filename] = append(res[filename], strconv.Itoa(int(delta.Milliseconds())))
 	}()
 
-	if ex, ok := format.(archiver.Extractor); ok {
-		err := ex.Extract(context.Background(), input, nil, func(ctx context.Context, f archiver.File) error {
-			if f.IsDir() {
-				return nil
-			}
-
-			outputPath := path.Join(root, f.NameInArchive)
-
-			// create symlinks
-			if f.LinkTarget != "" {
-				err := os.Symlink(f.LinkTarget, outputPath)
-				if err != nil {
-					return err
-				}
-				return nil
-			}
-
-			reader, err := f.Open()
-			if err != nil {
-				return err
-			}
-			defer reader.Close()
-
-			writer, err := safeCreateFile(outputPath, f.Mode())
-			if err != nil {
-				return fmt.Errorf("failed to create %v: %v", outputPath, err)
-			}
-			defer writer.Close()
-
-			if _, err := io.Copy(writer, reader); err != nil {
-				return fmt.Errorf("failed to write %v: %v", outputPath, err)
-			}
-
-			return nil
-		})
-		if err != nil {
-			log.Fatalf("failed extract: %s", err)
-			return
-		}
-	}
-}
-
-func safeCreateFile(filePath string, mode fs.FileMode) (*os.File, error) {
-	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory %v: %v", path.Dir(filePath), err)
+	err = extract.Extract(context.Background(), filename, inp, root, extract.DefaultLimits)
+	if err != nil {
+		log.Fatalf("failed extract: %s", err)
+		return
 	}
-
-	return os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 }
@@ -2,10 +2,16 @@ module test7z
 
 go 1.19
 
-require github.com/mholt/archiver/v4 v4.0.0-alpha.7.0.20221205195515-62ea3699423b
+require (
+	github.com/klauspost/compress v1.15.9
+	github.com/mholt/archiver/v4 v4.0.0-alpha.7.0.20221205195515-62ea3699423b
+	github.com/nikolaymatrosov/go-sls-search v0.0.0
+)
 
 replace github.com/mholt/archiver/v4 v4.0.0-alpha.7.0.20221205195515-62ea3699423b => github.com/nikolaymatrosov/archiver/v4 v4.0.0-20230107113914-5580627d01ec
 
+replace github.com/nikolaymatrosov/go-sls-search => ../../src
+
 require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/bodgit/plumbing v1.2.0 // indirect
@@ -16,7 +22,6 @@ require (
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/klauspost/pgzip v1.2.5 // indirect
 	github.com/nwaples/rardecode/v2 v2.0.0-beta.2 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
@@ -0,0 +1,7 @@
+module films
+
+go 1.19
+
+require github.com/nikolaymatrosov/go-sls-search v0.0.0
+
+replace github.com/nikolaymatrosov/go-sls-search => ../../src
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"github.com/nikolaymatrosov/go-sls-search/doc"
 	"log"
 	"os"
 	"path"
@@ -55,47 +56,29 @@ type FilmEntry struct {
 		} `json:"general"`
 	} `json:"data"`
 }
-type Film struct {
-	DocType             string `json:"_type"`
-	ForeignName         string `json:"foreignName"`
-	Filmname            string `json:"filmname"`
-	Studio              string `json:"studio"`
-	CrYearOfProduction  int    `json:"crYearOfProduction"`
-	Director            string `json:"director"`
-	ScriptAuthor        string `json:"scriptAuthor"`
-	Composer            string `json:"composer"`
-	Cameraman           string `json:"cameraman"`
-	Producer            string `json:"producer"`
-	Duration            int    `json:"duration"`
-	Color               string `json:"color"`
-	Annotation          string `json:"annotation"`
-	CountryOfProduction string `json:"countryOfProduction"`
-	Category            string `json:"category"`
-	AgeLimit            int    `json:"ageLimit"`
-}
 
-func (e FilmEntry) ToFilm() Film {
+func (e FilmEntry) ToFilm() doc.Film {
 	ageLimit, _ := strconv.Atoi(e.Data.General.AgeLimit)
 	mins, _ := strconv.Atoi(e.Data.General.DurationMinute)
 	hours, _ := strconv.Atoi(e.Data.General.DurationHour)
 	duration := hours*60 + mins
 	year, _ := strconv.Atoi(e.Data.General.CrYearOfProduction)
-	return Film{
-		DocType:             "film",
+	return doc.Film{
+		DocType:             doc.TypeFilm,
 		ForeignName:         e.Data.General.ForeignName,
 		Filmname:            e.Data.General.Filmname,
 		Studio:              e.Data.General.Studio,
-		CrYearOfProduction:  year,
+		CrYearOfProduction:  &year,
 		Director:            e.Data.General.Director,
 		ScriptAuthor:        e.Data.General.ScriptAuthor,
 		Composer:            e.Data.General.Composer,
 		Producer:            e.Data.General.Producer,
-		Duration:            duration,
+		Duration:            &duration,
 		Color:               e.Data.General.Color,
 		Annotation:          e.Data.General.Annotation,
 		CountryOfProduction: strings.ReplaceAll(e.Data.General.CountryOfProduction, "-", " "),
 		Category:            e.Data.General.Category,
-		AgeLimit:            ageLimit,
+		AgeLimit:            &ageLimit,
 	}
 }
 
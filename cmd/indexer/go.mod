@@ -0,0 +1,44 @@
+module indexer
+
+go 1.19
+
+require (
+	github.com/blevesearch/bleve v1.0.14
+	github.com/klauspost/compress v1.15.9
+	github.com/mholt/archiver/v4 v4.0.0-alpha.7
+	github.com/nikolaymatrosov/go-sls-search v0.0.0
+)
+
+replace github.com/nikolaymatrosov/go-sls-search => ../../src
+
+require (
+	github.com/RoaringBitmap/roaring v0.4.23 // indirect
+	github.com/andybalholm/brotli v1.0.4 // indirect
+	github.com/blevesearch/go-porterstemmer v1.0.3 // indirect
+	github.com/blevesearch/mmap-go v1.0.2 // indirect
+	github.com/blevesearch/segment v0.9.0 // indirect
+	github.com/blevesearch/snowballstem v0.9.0 // indirect
+	github.com/blevesearch/zap/v11 v11.0.14 // indirect
+	github.com/blevesearch/zap/v12 v12.0.14 // indirect
+	github.com/blevesearch/zap/v13 v13.0.6 // indirect
+	github.com/blevesearch/zap/v14 v14.0.5 // indirect
+	github.com/blevesearch/zap/v15 v15.0.3 // indirect
+	github.com/couchbase/vellum v1.0.2 // indirect
+	github.com/dsnet/compress v0.0.1 // indirect
+	github.com/glycerine/go-unsnap-stream v0.0.0-20181221182339-f9677308dec2 // indirect
+	github.com/golang/protobuf v1.3.3 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/klauspost/pgzip v1.2.5 // indirect
+	github.com/mschoch/smat v0.2.0 // indirect
+	github.com/nwaples/rardecode/v2 v2.0.0-beta.2 // indirect
+	github.com/philhofer/fwd v1.0.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/steveyen/gtreap v0.1.0 // indirect
+	github.com/therootcompany/xz v1.0.1 // indirect
+	github.com/tinylib/msgp v1.1.0 // indirect
+	github.com/ulikunitz/xz v0.5.10 // indirect
+	github.com/willf/bitset v1.1.10 // indirect
+	go.etcd.io/bbolt v1.3.5 // indirect
+	golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8 // indirect
+	golang.org/x/text v0.3.7 // indirect
+)
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/blevesearch/bleve"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru"
+	"github.com/blevesearch/bleve/index/scorch"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mholt/archiver/v4"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// The function extracts the archive into its cache dir and opens <cache>/index,
+// so everything has to live under the "index" folder of the archive.
+const indexDirInArchive = "index"
+
+func main() {
+	input := flag.String("input", "", "JSONL file with documents, one per line")
+	mappingFile := flag.String("mapping", "", "bleve index mapping, e.g. data/films/mapping.json")
+	out := flag.String("out", "index.tar.zst", "resulting archive")
+	workDir := flag.String("work", "", "directory to build the index in (temporary if empty)")
+	idField := flag.String("id", "", "document field used as id (line number if empty)")
+	batchSize := flag.Int("batch", 1000, "documents per batch")
+	progress := flag.Int("progress", 10000, "report progress every N documents")
+	version := flag.String("version", "", "index version recorded in the manifest (build time if empty)")
+	flag.Parse()
+
+	if *input == "" || *mappingFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := loadMapping(*mappingFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir := *workDir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "indexer")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	indexPath := path.Join(dir, indexDirInArchive)
+	if err := os.RemoveAll(indexPath); err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	count, err := buildIndex(indexPath, *input, m, *idField, *batchSize, *progress)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("indexed %d docs in %s\n", count, time.Now().Sub(start))
+
+	buildTime := time.Now().UTC()
+	start = time.Now()
+	checksum, err := archive(indexPath, *out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("archived %s in %s\n", *out, time.Now().Sub(start))
+
+	if *version == "" {
+		*version = buildTime.Format("20060102T150405Z")
+	}
+	mf := &manifest.Manifest{
+		Version:   *version,
+		BuildTime: buildTime,
+		DocCount:  uint64(count),
+		SHA256:    checksum,
+	}
+	manifestFile := manifest.KeyFor(*out)
+	err = mf.WriteFile(manifestFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %s, version %s\n", manifestFile, mf.Version)
+}
+
+func loadMapping(filename string) (*mapping.IndexMappingImpl, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := bleve.NewIndexMapping()
+	err = json.Unmarshal(contents, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mapping %v: %v", filename, err)
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping %v: %v", filename, err)
+	}
+	return m, nil
+}
+
+func buildIndex(indexPath, input string, m mapping.IndexMapping, idField string, batchSize, progress int) (int, error) {
+	index, err := bleve.NewUsing(indexPath, m, scorch.Name, scorch.Name, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer index.Close()
+
+	f, err := os.Open(input)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// annotations and jokes easily exceed the default 64k token limit
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	start := time.Now()
+	batch := index.NewBatch()
+	line := 0
+	count := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+		doc := map[string]interface{}{}
+		err := json.Unmarshal(data, &doc)
+		if err != nil {
+			return count, fmt.Errorf("%v:%d: %v", input, line, err)
+		}
+		id := strconv.Itoa(line)
+		if idField != "" {
+			v, ok := doc[idField]
+			if !ok {
+				return count, fmt.Errorf("%v:%d: field %q is missing", input, line, idField)
+			}
+			id = fmt.Sprint(v)
+		}
+		err = batch.Index(id, doc)
+		if err != nil {
+			return count, fmt.Errorf("%v:%d: %v", input, line, err)
+		}
+		count++
+
+		if batch.Size() >= batchSize {
+			err = index.Batch(batch)
+			if err != nil {
+				return count, err
+			}
+			batch.Reset()
+		}
+		if progress > 0 && count%progress == 0 {
+			elapsed := time.Now().Sub(start)
+			fmt.Printf("indexed %d docs, %.0f docs/s\n", count, float64(count)/elapsed.Seconds())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	if batch.Size() > 0 {
+		err = index.Batch(batch)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// archive packs the index and returns the hex encoded SHA-256 of the archive.
+func archive(indexPath, filename string) (string, error) {
+	store := path.Join(indexPath, "store")
+	files, err := os.ReadDir(store)
+	if err != nil {
+		return "", err
+	}
+	m := map[string]string{
+		path.Join(indexPath, "index_meta.json"): path.Join(indexDirInArchive, "index_meta.json"),
+	}
+	for _, file := range files {
+		m[path.Join(store, file.Name())] = path.Join(indexDirInArchive, "store", file.Name())
+	}
+
+	af, err := archiver.FilesFromDisk(nil, m)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	format := archiver.CompressedArchive{
+		Compression: archiver.Zstd{
+			EncoderOptions: []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBestCompression)},
+		},
+		Archival: archiver.Tar{},
+	}
+	h := sha256.New()
+	err = format.Archive(context.Background(), io.MultiWriter(out, h), af)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
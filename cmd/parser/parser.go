@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"github.com/nikolaymatrosov/go-sls-search/doc"
 	"log"
 	"os"
 	"strings"
@@ -10,10 +11,6 @@ import (
 
 const startMark = "<|startoftext|>"
 
-type Entry struct {
-	Joke string `json:"joke,omitempty"`
-}
-
 func main() {
 	f, err := os.Open("data/anek.txt")
 	defer f.Close()
@@ -33,8 +30,9 @@ func main() {
 		}
 		if strings.HasPrefix(l, startMark) {
 			if len(joke) != 0 {
-				j := &Entry{
-					Joke: joke,
+				j := &doc.Joke{
+					DocType: doc.TypeJoke,
+					Joke:    joke,
 				}
 				data, err := json.Marshal(j)
 				if err != nil {
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/blevesearch/bleve"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"github.com/nikolaymatrosov/go-sls-search/search"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// judgement is a line of the queries file: the query and the grades of
+// the documents relevant to it, e.g.
+//
+//	{"query": "терминатор", "relevant": {"1": 3, "13": 2}}
+//
+// Documents missing from relevant are not relevant. Fuzzy is passed to the
+// search request as is.
+type judgement struct {
+	Query    string         `json:"query"`
+	Fuzzy    string         `json:"fuzzy,omitempty"`
+	Relevant map[string]int `json:"relevant"`
+}
+
+// metrics of a single query or averaged over all of them.
+type metrics struct {
+	NDCG      float64
+	RR        float64
+	Precision float64
+	Recall    float64
+}
+
+// run is one configuration evaluated: an index build with a profile.
+type run struct {
+	name    string
+	index   bleve.Index
+	profile api.Profile
+}
+
+func main() {
+	indexPath := flag.String("index", "", "bleve index directory")
+	indexPathB := flag.String("index-b", "", "index to compare with, -index if empty")
+	queriesFile := flag.String("queries", "", "JSONL file with judged queries")
+	profile := flag.String("profile", search.DefaultProfile, "relevance profile, none searches the default field")
+	profileB := flag.String("profile-b", "", "profile to compare with, -profile if empty")
+	fuzzyFields := flag.String("fuzzy-fields", "filmname,foreignName,director", "fields searched by fuzzy queries")
+	k := flag.Int("k", 10, "number of hits evaluated")
+	verbose := flag.Bool("v", false, "print metrics of every query")
+	flag.Parse()
+
+	if *indexPath == "" || *queriesFile == "" || *k <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	judgements, err := loadJudgements(*queriesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a, err := newRun("A", *indexPath, *profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.index.Close()
+	runs := []*run{a}
+
+	if *indexPathB != "" || *profileB != "" {
+		b := &run{name: "B", index: a.index, profile: a.profile}
+		if *profileB != "" {
+			b.profile, err = api.ParseProfile(*profileB)
+			if err != nil {
+				log.Fatalf("-profile-b: %v", err)
+			}
+		}
+		if *indexPathB != "" {
+			b.index, err = bleve.Open(*indexPathB)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer b.index.Close()
+		}
+		runs = append(runs, b)
+	}
+
+	d := api.Defaults{FuzzyFields: strings.Split(*fuzzyFields, ",")}
+	results := make([][]metrics, len(runs))
+	for i, r := range runs {
+		d.Profile = r.profile
+		for _, j := range judgements {
+			m, err := evaluate(r.index, d, j, *k)
+			if err != nil {
+				log.Fatalf("%v: %q: %v", r.name, j.Query, err)
+			}
+			results[i] = append(results[i], m)
+		}
+	}
+
+	fmt.Printf("%d queries, k=%d\n", len(judgements), *k)
+	for _, r := range runs {
+		fmt.Printf("%v: index %v, profile %v\n", r.name, r.index.Name(), profileName(r.profile))
+	}
+	fmt.Println()
+	report(runs, results, *k)
+	if len(runs) == 2 {
+		fmt.Println()
+		diff(judgements, results, *k, *verbose)
+	} else if *verbose {
+		fmt.Println()
+		for i, j := range judgements {
+			m := results[0][i]
+			fmt.Printf("%-40q ndcg %.4f  rr %.4f  p %.4f  r %.4f\n", j.Query, m.NDCG, m.RR, m.Precision, m.Recall)
+		}
+	}
+}
+
+func newRun(name, indexPath, profile string) (*run, error) {
+	p, err := api.ParseProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("-profile: %v", err)
+	}
+	index, err := bleve.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	return &run{name: name, index: index, profile: p}, nil
+}
+
+func loadJudgements(filename string) ([]judgement, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []judgement
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		var j judgement
+		err := json.Unmarshal([]byte(data), &j)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", filename, line, err)
+		}
+		if j.Query == "" {
+			return nil, fmt.Errorf("%v:%d: query is empty", filename, line)
+		}
+		res = append(res, j)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%v has no queries", filename)
+	}
+	return res, nil
+}
+
+// evaluate searches the query the way SearchHandler does and scores the
+// top k hits against the judgement.
+func evaluate(index bleve.Index, d api.Defaults, j judgement, k int) (metrics, error) {
+	r := &api.SearchRequest{
+		Query:  j.Query,
+		Fuzzy:  j.Fuzzy,
+		Size:   &k,
+		Facets: map[string]*api.Facet{},
+	}
+	err := r.Validate()
+	if err != nil {
+		return metrics{}, err
+	}
+	_, res, _, err := r.Search(context.Background(), index, d)
+	if err != nil {
+		return metrics{}, err
+	}
+	ids := make([]string, len(res.Hits))
+	for i, hit := range res.Hits {
+		ids[i] = hit.ID
+	}
+	return score(ids, j.Relevant, k), nil
+}
+
+func score(ids []string, relevant map[string]int, k int) metrics {
+	var m metrics
+	found := 0
+	dcg := 0.0
+	for i, id := range ids {
+		grade := relevant[id]
+		if grade <= 0 {
+			continue
+		}
+		found++
+		dcg += gain(grade, i)
+		if m.RR == 0 {
+			m.RR = 1 / float64(i+1)
+		}
+	}
+
+	var grades []int
+	total := 0
+	for _, g := range relevant {
+		if g > 0 {
+			grades = append(grades, g)
+			total++
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(grades)))
+	ideal := 0.0
+	for i, g := range grades {
+		if i == k {
+			break
+		}
+		ideal += gain(g, i)
+	}
+
+	if ideal > 0 {
+		m.NDCG = dcg / ideal
+	}
+	m.Precision = float64(found) / float64(k)
+	if total > 0 {
+		m.Recall = float64(found) / float64(total)
+	}
+	return m
+}
+
+// gain of a document with the grade at the zero based rank.
+func gain(grade, rank int) float64 {
+	return (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(rank)+2)
+}
+
+func mean(ms []metrics) metrics {
+	var res metrics
+	for _, m := range ms {
+		res.NDCG += m.NDCG
+		res.RR += m.RR
+		res.Precision += m.Precision
+		res.Recall += m.Recall
+	}
+	n := float64(len(ms))
+	return metrics{res.NDCG / n, res.RR / n, res.Precision / n, res.Recall / n}
+}
+
+func report(runs []*run, results [][]metrics, k int) {
+	means := make([]metrics, len(runs))
+	fmt.Printf("%-10s", "")
+	for i, r := range runs {
+		means[i] = mean(results[i])
+		fmt.Printf("%10s", r.name)
+	}
+	if len(runs) == 2 {
+		fmt.Printf("%10s", "diff")
+	}
+	fmt.Println()
+
+	rows := []struct {
+		name  string
+		value func(metrics) float64
+	}{
+		{fmt.Sprintf("NDCG@%d", k), func(m metrics) float64 { return m.NDCG }},
+		{"MRR", func(m metrics) float64 { return m.RR }},
+		{fmt.Sprintf("P@%d", k), func(m metrics) float64 { return m.Precision }},
+		{fmt.Sprintf("R@%d", k), func(m metrics) float64 { return m.Recall }},
+	}
+	for _, row := range rows {
+		fmt.Printf("%-10s", row.name)
+		for _, m := range means {
+			fmt.Printf("%10.4f", row.value(m))
+		}
+		if len(means) == 2 {
+			fmt.Printf("%+10.4f", row.value(means[1])-row.value(means[0]))
+		}
+		fmt.Println()
+	}
+}
+
+// diff lists the queries whose NDCG changed between the runs, the biggest
+// changes first. With verbose the unchanged ones are listed as well.
+func diff(judgements []judgement, results [][]metrics, k int, verbose bool) {
+	type change struct {
+		query string
+		a, b  float64
+	}
+	var changes []change
+	for i, j := range judgements {
+		c := change{j.Query, results[0][i].NDCG, results[1][i].NDCG}
+		if verbose || math.Abs(c.b-c.a) > 1e-9 {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("no query changed")
+		return
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return math.Abs(changes[i].b-changes[i].a) > math.Abs(changes[j].b-changes[j].a)
+	})
+	better, worse := 0, 0
+	fmt.Printf("%-40s%10s%10s%10s\n", "query", "A", "B", "diff")
+	for _, c := range changes {
+		fmt.Printf("%-40q%10.4f%10.4f%+10.4f\n", c.query, c.a, c.b, c.b-c.a)
+		switch {
+		case c.b > c.a+1e-9:
+			better++
+		case c.a > c.b+1e-9:
+			worse++
+		}
+	}
+	fmt.Printf("NDCG@%d: %d queries better, %d worse\n", k, better, worse)
+}
+
+func profileName(p api.Profile) string {
+	if len(p) == 0 {
+		return "none"
+	}
+	return p.String()
+}
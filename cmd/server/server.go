@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/nikolaymatrosov/go-sls-search/search"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "address to listen on")
+	index := flag.String("index", "", "local index archive or directory, overrides SEARCH_SOURCE and SEARCH_LOCAL_PATH")
+	cacheDir := flag.String("cache", "", "directory the index is extracted to, overrides SEARCH_CACHE_DIR")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long running requests are waited for on shutdown")
+	flag.Parse()
+
+	// the rest of the configuration comes from the environment, as it does
+	// for the function
+	overrides := map[string]string{}
+	if *index != "" {
+		abs, err := filepath.Abs(*index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		overrides["SEARCH_SOURCE"] = "local"
+		overrides["SEARCH_LOCAL_PATH"] = abs
+	}
+	if *cacheDir != "" {
+		abs, err := filepath.Abs(*cacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		overrides["SEARCH_CACHE_DIR"] = abs
+	}
+	err := search.Configure(func(name string) string {
+		if v, ok := overrides[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", search.SearchHandler)
+	mux.HandleFunc("/search/", search.SearchHandler)
+	mux.HandleFunc("/suggest", search.SuggestHandler)
+	mux.HandleFunc("/suggest/", search.SuggestHandler)
+	mux.HandleFunc("/speed", search.SpeedHandler)
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintln(rw, "ok")
+	})
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: logRequests(mux),
+	}
+
+	// The search package closes the indexes on these signals as well. The
+	// context keeps them from terminating the process until the server is
+	// done, so it is not stopped before main returns.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %v", *addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("shutdown: %v", err)
+	}
+	search.Close()
+}
+
+// defaultAddr listens on $PORT, as most hosting environments expect.
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		log.Printf("%v %v %d %v", req.Method, req.URL, rec.status, time.Now().Sub(start))
+	})
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nikolaymatrosov/go-sls-search/doc"
+	"strconv"
+	"strings"
+)
+
+// Defaults are the per index settings used for whatever the request
+// doesn't specify.
+type Defaults struct {
+	Fields []string
+	Facets map[string]*Facet
+	// MaxSize limits the page size clients may ask for.
+	MaxSize int
+	// FieldRange is needed for numeric facets with automatic ranges.
+	FieldRange FieldRange
+	// FuzzyFields are searched by fuzzy queries.
+	FuzzyFields []string
+	// Profile weights the fields searched by plain text queries. Without
+	// one they are searched as query strings against the default field.
+	Profile Profile
+}
+
+// Build translates the request into a bleve search request in one of the
+// Modes of the request.
+func (r *SearchRequest) Build(d Defaults, mode string) (*bleve.SearchRequest, error) {
+	size := DefaultSize
+	if r.Size != nil {
+		size = *r.Size
+	}
+	if d.MaxSize > 0 && size > d.MaxSize {
+		return nil, badRequest("size must not exceed %d", d.MaxSize)
+	}
+	req := bleve.NewSearchRequestOptions(r.query(d, mode), size, r.From, false)
+
+	fields := d.Fields
+	if len(r.Fields) > 0 {
+		fields = r.Fields
+	}
+	// the type is needed to decode hits into documents
+	req.Fields = append([]string{}, fields...)
+	if !contains(fields, "*") && !contains(fields, doc.TypeField) {
+		req.Fields = append(req.Fields, doc.TypeField)
+	}
+	// hits with equal sort keys could be skipped or repeated between pages
+	// without a unique tie breaker
+	sort := []string{"-_score"}
+	if len(r.Sort) > 0 {
+		sort = append([]string{}, r.Sort...)
+	}
+	if !hasID(sort) {
+		sort = append(sort, "_id")
+	}
+	req.SortBy(sort)
+
+	if r.SearchAfter != "" {
+		after, err := decodeCursor(r.SearchAfter)
+		if err != nil {
+			return nil, err
+		}
+		if len(after) != len(req.Sort) {
+			return nil, badRequest("search_after doesn't match the sort order")
+		}
+		req.SetSearchAfter(after)
+	}
+
+	err := buildFacets(req, r.Facets, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// bleve can only produce a single fragment per field, so highlighting
+	// is done by ApplyHighlight after the search, bleve just has to collect
+	// the locations of the matched terms
+	if r.Highlight != nil {
+		req.IncludeLocations = true
+	}
+	return req, nil
+}
+
+// NextCursor returns the value of search_after for the page following res,
+// or an empty string if res is the last page.
+func NextCursor(req *bleve.SearchRequest, res *bleve.SearchResult) string {
+	if len(res.Hits) == 0 || len(res.Hits) < req.Size {
+		return ""
+	}
+	if req.SearchAfter == nil && uint64(req.From+len(res.Hits)) >= res.Total {
+		return ""
+	}
+	last := res.Hits[len(res.Hits)-1]
+	// bleve reports the literal "_score" as the sort value of the score,
+	// while search_after needs the score itself
+	after := append([]string{}, last.Sort...)
+	for i, s := range req.Sort {
+		if _, ok := s.(*search.SortScore); ok && i < len(after) {
+			after[i] = strconv.FormatFloat(last.Score, 'g', -1, 64)
+		}
+	}
+	data, err := json.Marshal(after)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) ([]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, badRequest("search_after is not a valid cursor")
+	}
+	var after []string
+	err = json.Unmarshal(data, &after)
+	if err != nil || len(after) == 0 {
+		return nil, badRequest("search_after is not a valid cursor")
+	}
+	return after, nil
+}
+
+func hasID(sort []string) bool {
+	for _, s := range sort {
+		if strings.TrimPrefix(s, "-") == "_id" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SearchRequest) query(d Defaults, mode string) query.Query {
+	var conjuncts []query.Query
+	if mode == ModeFuzzy {
+		if q := fuzzyQuery(r.Query, d.FuzzyFields); q != nil {
+			conjuncts = append(conjuncts, q)
+		} else if strings.TrimSpace(r.Query) != "" {
+			// only punctuation, which is no text to match rather than an
+			// empty query matching everything
+			conjuncts = append(conjuncts, bleve.NewMatchNoneQuery())
+		}
+	} else if strings.TrimSpace(r.Query) != "" {
+		simple := r.Syntax == SyntaxSimple
+		switch {
+		case len(d.Profile) > 0 && (simple || isPlainText(r.Query)):
+			conjuncts = append(conjuncts, d.Profile.query(r.Query))
+		case simple && strings.TrimSpace(escapeQuery(r.Query)) == "":
+			// only wildcards and slashes, which are no text to match and
+			// would leave an empty query string bleve can't parse
+			conjuncts = append(conjuncts, bleve.NewMatchNoneQuery())
+		case simple:
+			conjuncts = append(conjuncts, bleve.NewQueryStringQuery(escapeQuery(r.Query)))
+		default:
+			conjuncts = append(conjuncts, bleve.NewQueryStringQuery(r.Query))
+		}
+	}
+	for _, f := range r.Filters {
+		q := bleve.NewMatchQuery(f.Value)
+		q.SetField(f.Field)
+		q.SetOperator(query.MatchQueryOperatorAnd)
+		conjuncts = append(conjuncts, q)
+	}
+	for _, rng := range r.Ranges {
+		q := bleve.NewNumericRangeInclusiveQuery(rng.Min, rng.Max, rng.InclusiveMin, rng.InclusiveMax)
+		q.SetField(rng.Field)
+		conjuncts = append(conjuncts, q)
+	}
+
+	switch len(conjuncts) {
+	case 0:
+		return bleve.NewMatchAllQuery()
+	case 1:
+		return conjuncts[0]
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"strings"
+	"testing"
+)
+
+// newIndex returns an in-memory index of 25 films named after the
+// terminator, with different scores for the term and some equal ones.
+func newIndex(t *testing.T) bleve.Index {
+	t.Helper()
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	for i := 0; i < 25; i++ {
+		film := map[string]interface{}{
+			"filmname":           "Terminator " + strings.Repeat("sequel ", i%7),
+			"crYearOfProduction": 1984 + i,
+		}
+		if err := index.Index(fmt.Sprintf("d%02d", i), film); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return index
+}
+
+func TestSearchAfterPagesThroughAllHits(t *testing.T) {
+	index := newIndex(t)
+	for _, sort := range [][]string{nil, {"crYearOfProduction"}, {"_score"}, {"-_score", "-_id"}} {
+		t.Run(strings.Join(sort, ","), func(t *testing.T) {
+			size := 10
+			r := &SearchRequest{Query: "terminator", Size: &size, Sort: sort}
+			seen := map[string]bool{}
+			for page := 0; page < 5; page++ {
+				req, res, _, err := r.Search(context.Background(), index, Defaults{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, hit := range res.Hits {
+					if seen[hit.ID] {
+						t.Errorf("%v is repeated on page %d", hit.ID, page)
+					}
+					seen[hit.ID] = true
+				}
+				r.SearchAfter = NextCursor(req, res)
+				if r.SearchAfter == "" {
+					break
+				}
+			}
+			if len(seen) != 25 {
+				t.Errorf("paged through %d hits, want 25", len(seen))
+			}
+		})
+	}
+}
+
+func TestFuzzyWithoutWordsMatchesNothing(t *testing.T) {
+	index := newIndex(t)
+	d := Defaults{FuzzyFields: []string{"filmname"}}
+	tests := []struct {
+		query string
+		fuzzy string
+		total uint64
+	}{
+		{query: "!!!", fuzzy: FuzzyOn},
+		{query: "—", fuzzy: FuzzyOn},
+		{query: "!!!", fuzzy: FuzzyAuto},
+		{query: "", fuzzy: FuzzyOn, total: 25},
+		{query: "termintor", fuzzy: FuzzyOn, total: 25},
+	}
+	for _, tt := range tests {
+		r := &SearchRequest{Query: tt.query, Fuzzy: tt.fuzzy}
+		_, res, mode, err := r.Search(context.Background(), index, d)
+		if err != nil {
+			t.Fatalf("%q, fuzzy %v: %v", tt.query, tt.fuzzy, err)
+		}
+		if res.Total != tt.total {
+			t.Errorf("%q, fuzzy %v: %d hits in %v mode, want %d", tt.query, tt.fuzzy, res.Total, mode, tt.total)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FacetTerms   = "terms"
+	FacetNumeric = "numeric"
+	FacetDate    = "date"
+)
+
+// defaultFacetSize is used for terms facets that don't specify a size.
+const defaultFacetSize = 10
+
+// maxFacetBins bounds the number of automatically derived numeric bins.
+const maxFacetBins = 100
+
+// Facet describes one facet of the response. The type is inferred from the
+// other fields when omitted: ranges or interval make a numeric facet, date
+// ranges make a date facet, otherwise it's a terms facet over Field.
+type Facet struct {
+	Field string `json:"field,omitempty"`
+	Type  string `json:"type,omitempty"`
+	// Size is the number of terms or ranges returned.
+	Size          int               `json:"size,omitempty"`
+	NumericRanges []NumericFacetBin `json:"numericRanges,omitempty"`
+	// Interval asks for numeric bins of this width covering the values of
+	// Field in the index, e.g. 10 gives decades for years.
+	Interval   float64        `json:"interval,omitempty"`
+	DateRanges []DateFacetBin `json:"dateRanges,omitempty"`
+}
+
+type NumericFacetBin struct {
+	Name string   `json:"name"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+// DateFacetBin bounds are RFC 3339 timestamps.
+type DateFacetBin struct {
+	Name  string  `json:"name"`
+	Start *string `json:"start,omitempty"`
+	End   *string `json:"end,omitempty"`
+}
+
+// FieldRange reports the smallest and the largest value of a numeric field.
+// ok is false when no document has the field.
+type FieldRange func(field string) (min, max float64, ok bool, err error)
+
+// parseFacets reads the `facets` query string parameter. It is a comma
+// separated list where each item is either the name of an index default
+// facet, a field name for a terms facet, or field:interval for a numeric
+// facet with automatic bins. "none" or an empty value disables facets.
+func parseFacets(v string) (map[string]*Facet, error) {
+	facets := map[string]*Facet{}
+	if v == "" || v == "none" {
+		return facets, nil
+	}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, interval, ok := strings.Cut(item, ":")
+		if !ok {
+			// resolved against the defaults in Build
+			facets[name] = &Facet{}
+			continue
+		}
+		step, err := strconv.ParseFloat(interval, 64)
+		if err != nil || math.IsNaN(step) || math.IsInf(step, 0) {
+			return nil, badRequest("facets: interval of %v must be a number, got %q", name, interval)
+		}
+		facets[name] = &Facet{Field: name, Type: FacetNumeric, Interval: step}
+	}
+	return facets, nil
+}
+
+func (f *Facet) validate(name string) error {
+	if f == nil || f.Field == "" {
+		// reference to a default facet
+		return nil
+	}
+	if f.Size < 0 {
+		return badRequest("facets.%v: size must not be negative", name)
+	}
+	switch f.kind() {
+	case FacetTerms:
+	case FacetNumeric:
+		if len(f.NumericRanges) == 0 && f.Interval <= 0 {
+			return badRequest("facets.%v: numericRanges or a positive interval is required", name)
+		}
+		for i, bin := range f.NumericRanges {
+			if bin.Name == "" {
+				return badRequest("facets.%v.numericRanges[%d]: name is required", name, i)
+			}
+			if bin.Min == nil && bin.Max == nil {
+				return badRequest("facets.%v.numericRanges[%d]: min or max is required", name, i)
+			}
+		}
+	case FacetDate:
+		if len(f.DateRanges) == 0 {
+			return badRequest("facets.%v: dateRanges are required", name)
+		}
+		for i, bin := range f.DateRanges {
+			if bin.Name == "" {
+				return badRequest("facets.%v.dateRanges[%d]: name is required", name, i)
+			}
+			if bin.Start == nil && bin.End == nil {
+				return badRequest("facets.%v.dateRanges[%d]: start or end is required", name, i)
+			}
+			if err := validDate(bin.Start); err != nil {
+				return badRequest("facets.%v.dateRanges[%d]: start %v", name, i, err)
+			}
+			if err := validDate(bin.End); err != nil {
+				return badRequest("facets.%v.dateRanges[%d]: end %v", name, i, err)
+			}
+		}
+	default:
+		return badRequest("facets.%v: type must be terms, numeric or date, got %q", name, f.Type)
+	}
+	return nil
+}
+
+// validDate checks an optional bound of a date bin, bleve silently drops
+// bins with bounds it can't parse.
+func validDate(v *string) error {
+	if v == nil {
+		return nil
+	}
+	if _, err := time.Parse(time.RFC3339, *v); err != nil {
+		return fmt.Errorf("must be an RFC 3339 timestamp, got %q", *v)
+	}
+	return nil
+}
+
+func (f *Facet) kind() string {
+	switch {
+	case f.Type != "":
+		return f.Type
+	case len(f.NumericRanges) > 0 || f.Interval > 0:
+		return FacetNumeric
+	case len(f.DateRanges) > 0:
+		return FacetDate
+	}
+	return FacetTerms
+}
+
+// buildFacets resolves references to the defaults and turns the facets
+// into bleve requests.
+func buildFacets(req *bleve.SearchRequest, requested map[string]*Facet, d Defaults) error {
+	facets := d.Facets
+	if requested != nil {
+		facets = requested
+	}
+	for name, f := range facets {
+		if f == nil || f.Field == "" {
+			def, ok := d.Facets[name]
+			if ok {
+				f = def
+			} else {
+				f = &Facet{Field: name}
+			}
+		}
+		fr, err := f.request(name, d.FieldRange)
+		if err != nil {
+			return err
+		}
+		req.AddFacet(name, fr)
+	}
+	return nil
+}
+
+func (f *Facet) request(name string, fieldRange FieldRange) (*bleve.FacetRequest, error) {
+	size := f.Size
+	switch f.kind() {
+	case FacetNumeric:
+		bins := f.NumericRanges
+		if len(bins) == 0 {
+			var err error
+			bins, err = f.autoBins(name, fieldRange)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if size == 0 {
+			size = len(bins)
+		}
+		req := bleve.NewFacetRequest(f.Field, size)
+		for _, bin := range bins {
+			req.AddNumericRange(bin.Name, bin.Min, bin.Max)
+		}
+		return req, nil
+	case FacetDate:
+		if size == 0 {
+			size = len(f.DateRanges)
+		}
+		req := bleve.NewFacetRequest(f.Field, size)
+		for _, bin := range f.DateRanges {
+			req.AddDateTimeRangeString(bin.Name, bin.Start, bin.End)
+		}
+		return req, nil
+	}
+	if size == 0 {
+		size = defaultFacetSize
+	}
+	return bleve.NewFacetRequest(f.Field, size), nil
+}
+
+// autoBins splits the values of the field into bins of f.Interval width
+// aligned to multiples of the interval.
+func (f *Facet) autoBins(name string, fieldRange FieldRange) ([]NumericFacetBin, error) {
+	if fieldRange == nil {
+		return nil, badRequest("facets.%v: automatic ranges are not supported", name)
+	}
+	min, max, ok, err := fieldRange(f.Field)
+	if err != nil {
+		return nil, fmt.Errorf("facets.%v: %w", name, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	start := math.Floor(min/f.Interval) * f.Interval
+	// an interval below the float spacing of the values would make bins of
+	// no width
+	if start+f.Interval == start {
+		return nil, badRequest("facets.%v: interval %v is too small for values around %v", name, f.Interval, start)
+	}
+	// the last bin holds max, bins exclude their upper bound
+	count := math.Floor((max-start)/f.Interval) + 1
+	if count > maxFacetBins {
+		return nil, badRequest("facets.%v: interval %v gives more than %d ranges", name, f.Interval, maxFacetBins)
+	}
+	bins := make([]NumericFacetBin, 0, int(count))
+	for i := 0; i < int(count); i++ {
+		lo := start + float64(i)*f.Interval
+		hi := lo + f.Interval
+		bins = append(bins, NumericFacetBin{
+			Name: strconv.FormatFloat(lo, 'f', -1, 64),
+			Min:  &lo,
+			Max:  &hi,
+		})
+	}
+	return bins, nil
+}
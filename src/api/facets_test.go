@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseFacetsRejectsIntervals(t *testing.T) {
+	for _, v := range []string{"year:", "year:ten", "year:NaN", "year:Inf", "year:-Inf"} {
+		_, err := parseFacets(v)
+		var e *Error
+		if !errors.As(err, &e) || e.Status != http.StatusBadRequest {
+			t.Errorf("%v: got %v, want a bad request", v, err)
+		}
+	}
+}
+
+func TestAutoBins(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval float64
+		min, max float64
+		// bins are the names of the bins, nil when the interval is rejected
+		bins []string
+	}{
+		{name: "decades", interval: 10, min: 1984, max: 2008, bins: []string{"1980", "1990", "2000"}},
+		{name: "max on a bin edge", interval: 10, min: 1980, max: 2000, bins: []string{"1980", "1990", "2000"}},
+		{name: "single value", interval: 10, min: 1984, max: 1984, bins: []string{"1980"}},
+		{name: "fractions", interval: 0.5, min: 1, max: 2, bins: []string{"1", "1.5", "2"}},
+		{name: "too many bins", interval: 1, min: 0, max: 100},
+		{name: "below float spacing", interval: 1e-14, min: 1984, max: 1984},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Facet{Field: "year", Interval: tt.interval}
+			bins, err := f.autoBins("year", func(string) (float64, float64, bool, error) {
+				return tt.min, tt.max, true, nil
+			})
+			if tt.bins == nil {
+				var e *Error
+				if !errors.As(err, &e) || e.Status != http.StatusBadRequest {
+					t.Fatalf("got %v, want a bad request", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, bin := range bins {
+				names = append(names, bin.Name)
+				if *bin.Max-*bin.Min != tt.interval {
+					t.Errorf("bin %v is %v wide, want %v", bin.Name, *bin.Max-*bin.Min, tt.interval)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.bins) {
+				t.Errorf("bins %v, want %v", names, tt.bins)
+			}
+		})
+	}
+}
+
+func TestValidateDateRanges(t *testing.T) {
+	valid, garbage := "2001-02-03T04:05:06Z", "garbage"
+	tests := []struct {
+		name string
+		bin  DateFacetBin
+		err  string
+	}{
+		{name: "valid", bin: DateFacetBin{Name: "new", Start: &valid, End: &valid}},
+		{name: "open end", bin: DateFacetBin{Name: "new", Start: &valid}},
+		{
+			name: "bad start",
+			bin:  DateFacetBin{Name: "new", Start: &garbage, End: &valid},
+			err:  `facets.added.dateRanges[0]: start must be an RFC 3339 timestamp, got "garbage"`,
+		},
+		{
+			name: "bad end",
+			bin:  DateFacetBin{Name: "new", End: &garbage},
+			err:  `facets.added.dateRanges[0]: end must be an RFC 3339 timestamp, got "garbage"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Facet{Field: "added", DateRanges: []DateFacetBin{tt.bin}}
+			err := f.validate("added")
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Status != http.StatusBadRequest || e.Message != tt.err {
+				t.Errorf("got %v, want a bad request: %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Values of SearchRequest.Fuzzy.
+const (
+	// FuzzyOff searches the query as is, it is the default.
+	FuzzyOff = "off"
+	// FuzzyOn rewrites the query into fuzzy match queries.
+	FuzzyOn = "on"
+	// FuzzyAuto searches the query as is and falls back to fuzzy matching
+	// only when nothing is found.
+	FuzzyAuto = "auto"
+)
+
+// Modes of a search, reported in the response.
+const (
+	ModeExact = "exact"
+	ModeFuzzy = "fuzzy"
+)
+
+// Modes returns the modes to search in, one after another until there are
+// hits.
+func (r *SearchRequest) Modes() []string {
+	switch r.Fuzzy {
+	case FuzzyOn:
+		return []string{ModeFuzzy}
+	case FuzzyAuto:
+		return []string{ModeExact, ModeFuzzy}
+	}
+	return []string{ModeExact}
+}
+
+// Search builds the request in every mode of the request until one finds
+// anything and returns the search made in that mode. Errors of Build are
+// *Error, the rest come from the index. When ctx is done before a later
+// mode is searched, the search of the earlier one is returned, as finding
+// nothing is still an answer.
+func (r *SearchRequest) Search(ctx context.Context, index bleve.Index, d Defaults) (*bleve.SearchRequest, *bleve.SearchResult, string, error) {
+	var (
+		found string
+		req   *bleve.SearchRequest
+		res   *bleve.SearchResult
+	)
+	for _, mode := range r.Modes() {
+		built, err := r.Build(d, mode)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		result, err := index.SearchInContext(ctx, built)
+		if err != nil && res != nil && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		found, req, res = mode, built, result
+		if res.Total > 0 {
+			break
+		}
+	}
+	return req, res, found, nil
+}
+
+func validFuzzy(v string) bool {
+	switch v {
+	case "", FuzzyOff, FuzzyOn, FuzzyAuto:
+		return true
+	}
+	return false
+}
+
+// fuzzyQuery treats the query as plain words typed by the user, any query
+// string syntax is ignored. Every word has to match one of the fields
+// within an edit distance that grows with the length of the word: short
+// words are matched exactly as any of them is a typo of too many others.
+func fuzzyQuery(text string, fields []string) query.Query {
+	words := plainWords(text)
+	var conjuncts []query.Query
+	for _, w := range words {
+		var disjuncts []query.Query
+		for _, f := range fields {
+			q := bleve.NewMatchQuery(w)
+			q.SetField(f)
+			q.SetFuzziness(fuzziness(w))
+			disjuncts = append(disjuncts, q)
+		}
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(disjuncts...))
+	}
+	switch len(conjuncts) {
+	case 0:
+		return nil
+	case 1:
+		return conjuncts[0]
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+// fuzziness is the edit distance allowed for a word, bleve supports up to 2.
+func fuzziness(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	}
+	return 2
+}
+
+// plainWords splits the text into words dropping punctuation along with
+// the query string syntax, including field names of `field:value`.
+func plainWords(text string) []string {
+	var words []string
+	for _, token := range strings.Fields(text) {
+		if i := strings.LastIndex(token, ":"); i >= 0 {
+			token = token[i+1:]
+		}
+		words = append(words, strings.FieldsFunc(token, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+	return words
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/highlight"
+	"github.com/blevesearch/bleve/search/highlight/format/ansi"
+	"github.com/blevesearch/bleve/search/highlight/format/html"
+	"github.com/blevesearch/bleve/search/highlight/fragmenter/simple"
+	simpleHighlighter "github.com/blevesearch/bleve/search/highlight/highlighter/simple"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	HighlightHTML   = "html"
+	HighlightANSI   = "ansi"
+	HighlightMarker = "marker"
+)
+
+const (
+	defaultFragmentSize = 200
+	maxFragmentSize     = 1000
+	maxFragments        = 5
+)
+
+type Highlight struct {
+	// Style is html (default), ansi or marker. Html escapes the text and
+	// wraps matches into <mark>, marker wraps them into plain text markers.
+	Style string `json:"style,omitempty"`
+	// Fields to highlight, all fields with matches when empty.
+	Fields []string `json:"fields,omitempty"`
+	// FragmentSize is the approximate length of a fragment in characters.
+	FragmentSize int `json:"fragmentSize,omitempty"`
+	// Fragments is the maximum number of fragments per field.
+	Fragments int `json:"fragments,omitempty"`
+	// Before and After override the markers of the html and marker styles.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// parseHighlight reads `highlight` (comma separated fields, empty for all),
+// `highlight_style`, `fragment_size` and `fragments` of a GET request.
+func parseHighlight(q url.Values) (*Highlight, error) {
+	h := &Highlight{Style: q.Get("highlight_style")}
+	if v := q.Get("highlight"); v != "" {
+		h.Fields = strings.Split(v, ",")
+	}
+	if v := q.Get("fragment_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("fragment_size must be an integer, got %q", v)
+		}
+		h.FragmentSize = size
+	}
+	if v := q.Get("fragments"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("fragments must be an integer, got %q", v)
+		}
+		h.Fragments = n
+	}
+	return h, nil
+}
+
+func (h *Highlight) validate() error {
+	switch h.Style {
+	case "", HighlightHTML, HighlightANSI, HighlightMarker:
+	default:
+		return badRequest("highlight.style must be html, ansi or marker, got %q", h.Style)
+	}
+	if h.FragmentSize < 0 || h.FragmentSize > maxFragmentSize {
+		return badRequest("highlight.fragmentSize must be between 0 (the default) and %d", maxFragmentSize)
+	}
+	if h.Fragments < 0 || h.Fragments > maxFragments {
+		return badRequest("highlight.fragments must be between 0 (the default) and %d", maxFragments)
+	}
+	for i, f := range h.Fields {
+		if f == "" {
+			return badRequest("highlight.fields[%d]: field is required", i)
+		}
+	}
+	return nil
+}
+
+func (h *Highlight) highlighter() highlight.Highlighter {
+	size := h.FragmentSize
+	if size == 0 {
+		size = defaultFragmentSize
+	}
+
+	var formatter highlight.FragmentFormatter
+	switch h.Style {
+	case HighlightANSI:
+		formatter = ansi.NewFragmentFormatter(ansi.DefaultAnsiHighlight)
+	case HighlightMarker:
+		formatter = &markerFormatter{before: or(h.Before, "**"), after: or(h.After, "**")}
+	default:
+		formatter = html.NewFragmentFormatter(or(h.Before, "<mark>"), or(h.After, "</mark>"))
+	}
+	return simpleHighlighter.NewHighlighter(simple.NewFragmenter(size), formatter, "…")
+}
+
+// ApplyHighlight fills fragments of the hits of a search built from a
+// request with highlighting. The term locations needed for it are dropped
+// from the hits afterwards, as the client didn't ask for them. Hits of an
+// alias search are matched to the indexes they came from by index name.
+func ApplyHighlight(indexes []bleve.Index, h *Highlight, res *bleve.SearchResult) error {
+	if h == nil {
+		return nil
+	}
+	highlighter := h.highlighter()
+	count := h.Fragments
+	if count == 0 {
+		count = 1
+	}
+
+	byName := map[string]bleve.Index{}
+	for _, index := range indexes {
+		byName[index.Name()] = index
+	}
+	for _, hit := range res.Hits {
+		index, ok := byName[hit.Index]
+		if !ok && len(indexes) == 1 {
+			index, ok = indexes[0], true
+		}
+		if !ok {
+			return fmt.Errorf("hit %v comes from unknown index %v", hit.ID, hit.Index)
+		}
+		doc, err := index.Document(hit.ID)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+		fields := h.Fields
+		if len(fields) == 0 {
+			for f := range hit.Locations {
+				fields = append(fields, f)
+			}
+		}
+		for _, f := range fields {
+			fragments := highlighter.BestFragmentsInField(hit, doc, f, count)
+			if len(fragments) == 0 {
+				continue
+			}
+			if hit.Fragments == nil {
+				hit.Fragments = search.FieldFragmentMap{}
+			}
+			hit.Fragments[f] = fragments
+		}
+		hit.Locations = nil
+	}
+	return nil
+}
+
+// markerFormatter is the html formatter without escaping, for clients that
+// render plain text.
+type markerFormatter struct {
+	before string
+	after  string
+}
+
+func (m *markerFormatter) Format(f *highlight.Fragment, orderedTermLocations highlight.TermLocations) string {
+	var sb strings.Builder
+	curr := f.Start
+	for _, termLocation := range orderedTermLocations {
+		if termLocation == nil {
+			continue
+		}
+		if !termLocation.ArrayPositions.Equals(f.ArrayPositions) {
+			continue
+		}
+		if termLocation.Start < curr {
+			continue
+		}
+		if termLocation.End > f.End {
+			break
+		}
+		sb.Write(f.Orig[curr:termLocation.Start])
+		sb.WriteString(m.before)
+		sb.Write(f.Orig[termLocation.Start:termLocation.End])
+		sb.WriteString(m.after)
+		curr = termLocation.End
+	}
+	sb.Write(f.Orig[curr:f.End])
+	return sb.String()
+}
+
+func or(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile tells how much a match in every field is worth for plain text
+// queries. Queries using the query string syntax pick their fields and
+// boosts themselves and ignore the profile.
+type Profile []FieldBoost
+
+type FieldBoost struct {
+	Field string  `json:"field"`
+	Boost float64 `json:"boost"`
+}
+
+// phraseBoost multiplies the boost of a field for documents matching the
+// whole query as a phrase, so the exact title ranks above titles sharing
+// a word with it.
+const phraseBoost = 2
+
+// ParseProfile reads a profile in the query string boost syntax, e.g.
+// "filmname^4,foreignName^3,director^2,annotation". Boost defaults to 1.
+// "none" and an empty string stand for no profile.
+func ParseProfile(s string) (Profile, error) {
+	if strings.TrimSpace(s) == "none" {
+		return nil, nil
+	}
+	var p Profile
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fb := FieldBoost{Field: item, Boost: 1}
+		if i := strings.LastIndex(item, "^"); i >= 0 {
+			boost, err := strconv.ParseFloat(item[i+1:], 64)
+			if err != nil || boost <= 0 {
+				return nil, fmt.Errorf("invalid boost of %v", item)
+			}
+			fb = FieldBoost{Field: item[:i], Boost: boost}
+		}
+		if fb.Field == "" {
+			return nil, fmt.Errorf("field of %v is empty", item)
+		}
+		p = append(p, fb)
+	}
+	return p, nil
+}
+
+func (p Profile) String() string {
+	items := make([]string, len(p))
+	for i, fb := range p {
+		items[i] = fb.Field + "^" + strconv.FormatFloat(fb.Boost, 'g', -1, 64)
+	}
+	return strings.Join(items, ",")
+}
+
+// Merge combines profiles of the indexes of an alias, a field shared by
+// several of them keeps the highest boost.
+func (p Profile) Merge(other Profile) Profile {
+	res := append(Profile{}, p...)
+	for _, fb := range other {
+		found := false
+		for i := range res {
+			if res[i].Field == fb.Field {
+				found = true
+				if fb.Boost > res[i].Boost {
+					res[i].Boost = fb.Boost
+				}
+			}
+		}
+		if !found {
+			res = append(res, fb)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Boost > res[j].Boost
+	})
+	return res
+}
+
+// query searches the text in every field of the profile, the best scoring
+// fields of a document add up.
+func (p Profile) query(text string) query.Query {
+	multiword := len(strings.Fields(text)) > 1
+	var disjuncts []query.Query
+	for _, fb := range p {
+		m := bleve.NewMatchQuery(text)
+		m.SetField(fb.Field)
+		m.SetBoost(fb.Boost)
+		disjuncts = append(disjuncts, m)
+		if multiword {
+			ph := bleve.NewMatchPhraseQuery(text)
+			ph.SetField(fb.Field)
+			ph.SetBoost(fb.Boost * phraseBoost)
+			disjuncts = append(disjuncts, ph)
+		}
+	}
+	return bleve.NewDisjunctionQuery(disjuncts...)
+}
+
+// isPlainText tells whether the query was typed as plain words rather
+// than in the query string syntax: no field names, boosts, fuzziness,
+// wildcards, phrases, grouping or required and excluded terms.
+func isPlainText(q string) bool {
+	for _, token := range strings.Fields(q) {
+		if strings.HasPrefix(token, "+") || strings.HasPrefix(token, "-") {
+			return false
+		}
+		if strings.ContainsAny(token, `:^~*"()\/<>=`) {
+			return false
+		}
+	}
+	return true
+}
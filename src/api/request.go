@@ -0,0 +1,266 @@
+// Package api defines the JSON schema of the search function and its
+// translation into bleve requests.
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchRequest is the body of a POST search request. The GET form with a
+// single `term` parameter is mapped onto it as well.
+type SearchRequest struct {
+	// Query uses the bleve query string syntax unless Syntax is simple.
+	// Empty query matches all documents, which is useful together with
+	// filters and ranges.
+	Query string `json:"query"`
+	// Syntax is query or simple, see SyntaxQuery and SyntaxSimple.
+	Syntax string `json:"syntax,omitempty"`
+	// Fuzzy is off, on or auto, see FuzzyOff, FuzzyOn and FuzzyAuto.
+	Fuzzy string `json:"fuzzy,omitempty"`
+	// Filters and Ranges narrow down the documents matched by Query. All of
+	// them have to match.
+	Filters []FieldFilter  `json:"filters,omitempty"`
+	Ranges  []NumericRange `json:"ranges,omitempty"`
+
+	From int  `json:"from,omitempty"`
+	Size *int `json:"size,omitempty"`
+	// Sort lists fields to sort by, "-" prefix means descending order.
+	// "_score" and "_id" are accepted as well.
+	Sort []string `json:"sort,omitempty"`
+	// SearchAfter is the cursor returned with the previous page. It allows
+	// paging deeper than from/size can go and can't be combined with From.
+	SearchAfter string `json:"searchAfter,omitempty"`
+	// Fields are the stored fields returned with hits. The index defaults
+	// are used when empty.
+	Fields []string `json:"fields,omitempty"`
+	// Facets are keyed by the name they have in the response. When nil the
+	// index defaults are computed, an empty object disables facets. A facet
+	// without a field refers to the index default with the same name.
+	Facets    map[string]*Facet `json:"facets,omitempty"`
+	Highlight *Highlight        `json:"highlight,omitempty"`
+}
+
+// FieldFilter requires Field to match Value. Value is analyzed with the
+// analyzer of the field.
+type FieldFilter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// NumericRange requires a numeric Field to be within [Min, Max). Either of
+// the bounds may be omitted.
+type NumericRange struct {
+	Field        string   `json:"field"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	InclusiveMin *bool    `json:"inclusiveMin,omitempty"`
+	InclusiveMax *bool    `json:"inclusiveMax,omitempty"`
+}
+
+// DefaultSize is the number of hits returned when the request has no size.
+const DefaultSize = 10
+
+// Error is a problem with the request the client has to fix.
+type Error struct {
+	Status  int
+	Message string
+	// Syntax locates the problem in a malformed query string.
+	Syntax *SyntaxError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// maxBodySize is way above any reasonable request and protects the function
+// memory from unreasonable ones.
+const maxBodySize = 64 << 10
+
+// Parse reads the search request from either the query string of a GET
+// request (`term`, `syntax`, `fuzzy`, `from`, `size`, `sort`, `search_after`, `facets`
+// and `highlight` with its options) or the JSON body of a POST request.
+func Parse(req *http.Request) (*SearchRequest, error) {
+	var r *SearchRequest
+	switch req.Method {
+	case http.MethodGet:
+		if len(req.URL.Query().Get("term")) == 0 {
+			return nil, badRequest("query string parametr 'term' is missing")
+		}
+		var err error
+		r, err = fromQuery(req.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+	case http.MethodPost:
+		var err error
+		r, err = decode(io.LimitReader(req.Body, maxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &Error{Status: http.StatusMethodNotAllowed, Message: fmt.Sprintf("method %v is not allowed", req.Method)}
+	}
+
+	err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func fromQuery(q url.Values) (*SearchRequest, error) {
+	r := &SearchRequest{
+		Query:       q.Get("term"),
+		Syntax:      q.Get("syntax"),
+		Fuzzy:       q.Get("fuzzy"),
+		SearchAfter: q.Get("search_after"),
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("from must be an integer, got %q", v)
+		}
+		r.From = from
+	}
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("size must be an integer, got %q", v)
+		}
+		r.Size = &size
+	}
+	if v := q.Get("sort"); v != "" {
+		r.Sort = strings.Split(v, ",")
+	}
+	if _, ok := q["highlight"]; ok {
+		h, err := parseHighlight(q)
+		if err != nil {
+			return nil, err
+		}
+		r.Highlight = h
+	}
+	if _, ok := q["facets"]; ok {
+		facets, err := parseFacets(q.Get("facets"))
+		if err != nil {
+			return nil, err
+		}
+		r.Facets = facets
+	}
+	return r, nil
+}
+
+func decode(body io.Reader) (*SearchRequest, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBodySize {
+		return nil, &Error{Status: http.StatusRequestEntityTooLarge, Message: "request body is too large"}
+	}
+
+	r := &SearchRequest{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(r)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil, badRequest("request body is empty")
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return nil, badRequest("malformed JSON: unexpected end of request body")
+		case errors.As(err, &syntaxErr):
+			return nil, badRequest("malformed JSON at offset %d: %v", syntaxErr.Offset, err)
+		case errors.As(err, &typeErr):
+			return nil, badRequest("field %q must be %v", typeErr.Field, typeErr.Type)
+		}
+		return nil, badRequest("invalid request: %v", err)
+	}
+	if dec.More() {
+		return nil, badRequest("request body must contain a single JSON object")
+	}
+	return r, nil
+}
+
+// Validate checks the request doesn't contain anything Build can't handle.
+func (r *SearchRequest) Validate() error {
+	if strings.TrimSpace(r.Query) == "" && len(r.Filters) == 0 && len(r.Ranges) == 0 {
+		return badRequest("either query, filters or ranges are required")
+	}
+	if !validSyntax(r.Syntax) {
+		return badRequest("syntax must be query or simple, got %q", r.Syntax)
+	}
+	if r.Syntax != SyntaxSimple && strings.TrimSpace(r.Query) != "" {
+		err := checkSyntax(r.Query)
+		if err != nil {
+			return err
+		}
+	}
+	if !validFuzzy(r.Fuzzy) {
+		return badRequest("fuzzy must be off, on or auto, got %q", r.Fuzzy)
+	}
+	for i, f := range r.Filters {
+		if f.Field == "" {
+			return badRequest("filters[%d]: field is required", i)
+		}
+		if f.Value == "" {
+			return badRequest("filters[%d]: value is required", i)
+		}
+	}
+	for i, rng := range r.Ranges {
+		if rng.Field == "" {
+			return badRequest("ranges[%d]: field is required", i)
+		}
+		if rng.Min == nil && rng.Max == nil {
+			return badRequest("ranges[%d]: min or max is required", i)
+		}
+		if rng.Min != nil && rng.Max != nil && *rng.Min > *rng.Max {
+			return badRequest("ranges[%d]: min is greater than max", i)
+		}
+	}
+	if r.From < 0 {
+		return badRequest("from must not be negative")
+	}
+	if r.Size != nil && *r.Size < 0 {
+		return badRequest("size must not be negative")
+	}
+	if r.SearchAfter != "" && r.From != 0 {
+		return badRequest("from can't be used together with search_after")
+	}
+	for i, s := range r.Sort {
+		if strings.TrimPrefix(s, "-") == "" {
+			return badRequest("sort[%d]: field is required", i)
+		}
+	}
+	for i, f := range r.Fields {
+		if f == "" {
+			return badRequest("fields[%d]: field is required", i)
+		}
+	}
+	for name, f := range r.Facets {
+		err := f.validate(name)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Highlight != nil {
+		err := r.Highlight.validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/nikolaymatrosov/go-sls-search/doc"
+	"sort"
+)
+
+// ResponseVersion is bumped on every incompatible change of SearchResponse.
+const ResponseVersion = 1
+
+// SearchResponse is what the search function returns. Unlike
+// bleve.SearchResult it doesn't change with bleve upgrades.
+type SearchResponse struct {
+	Version  int     `json:"version"`
+	Total    uint64  `json:"total"`
+	MaxScore float64 `json:"maxScore"`
+	// Mode the hits were found in, exact or fuzzy.
+	Mode   string                 `json:"mode"`
+	Page   Page                   `json:"page"`
+	Hits   []Hit                  `json:"hits"`
+	Facets map[string]FacetResult `json:"facets,omitempty"`
+	// DidYouMean are corrected queries offered when nothing is found, see
+	// DidYouMean.
+	DidYouMean []string `json:"didYouMean,omitempty"`
+	// TimedOut tells that the request ran out of time: some indexes of an
+	// alias may be missing from the hits, highlights and did you mean
+	// suggestions may be missing as well.
+	TimedOut bool `json:"timedOut,omitempty"`
+	// Durations of the search stages in microseconds.
+	Durations map[string]int64 `json:"durations"`
+}
+
+type Page struct {
+	From int `json:"from"`
+	Size int `json:"size"`
+	// NextSearchAfter is passed as search_after to get the next page. It is
+	// empty on the last page.
+	NextSearchAfter string `json:"nextSearchAfter,omitempty"`
+}
+
+type Hit struct {
+	ID string `json:"id"`
+	// Index the hit comes from, it matters for searches over an alias.
+	Index string  `json:"index,omitempty"`
+	Score float64 `json:"score"`
+	// Type is the document type, it tells the shape of Fields: doc.Film for
+	// films, doc.Joke for jokes and the stored fields as is otherwise.
+	Type   string      `json:"type,omitempty"`
+	Fields interface{} `json:"fields,omitempty"`
+	// Highlights are the fragments of the fields with matched terms marked.
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+type FacetResult struct {
+	Field   string   `json:"field"`
+	Total   int      `json:"total"`
+	Missing int      `json:"missing"`
+	Other   int      `json:"other"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Bucket is a term of a terms facet or a range of a numeric or date facet.
+// Terms are ordered by count, ranges by their bounds.
+type Bucket struct {
+	Key   string   `json:"key"`
+	Count int      `json:"count"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Start *string  `json:"start,omitempty"`
+	End   *string  `json:"end,omitempty"`
+}
+
+// NewSearchResponse converts the result. Hits without the type field are
+// decoded as documents of the default type of their index, defaultTypes
+// maps index names to them.
+func NewSearchResponse(req *bleve.SearchRequest, res *bleve.SearchResult, mode string, defaultTypes map[string]string, durations map[string]int64) *SearchResponse {
+	resp := &SearchResponse{
+		Version:  ResponseVersion,
+		Total:    res.Total,
+		MaxScore: res.MaxScore,
+		Mode:     mode,
+		Page: Page{
+			From:            req.From,
+			Size:            req.Size,
+			NextSearchAfter: NextCursor(req, res),
+		},
+		Hits:      make([]Hit, 0, len(res.Hits)),
+		Durations: durations,
+	}
+	for _, h := range res.Hits {
+		hit := Hit{
+			ID:         h.ID,
+			Index:      h.Index,
+			Score:      h.Score,
+			Highlights: h.Fragments,
+		}
+		if len(h.Fields) > 0 {
+			hit.Type, hit.Fields = doc.Decode(h.Fields, defaultTypes[h.Index])
+		}
+		resp.Hits = append(resp.Hits, hit)
+	}
+	if len(res.Facets) > 0 {
+		resp.Facets = make(map[string]FacetResult, len(res.Facets))
+		for name, f := range res.Facets {
+			resp.Facets[name] = newFacetResult(f)
+		}
+	}
+	return resp
+}
+
+func newFacetResult(f *search.FacetResult) FacetResult {
+	res := FacetResult{
+		Field:   f.Field,
+		Total:   f.Total,
+		Missing: f.Missing,
+		Other:   f.Other,
+		Buckets: []Bucket{},
+	}
+	for _, t := range f.Terms {
+		res.Buckets = append(res.Buckets, Bucket{Key: t.Term, Count: t.Count})
+	}
+
+	ranges := append(search.NumericRangeFacets{}, f.NumericRanges...)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return lessBound(ranges[i].Min, ranges[j].Min)
+	})
+	for _, r := range ranges {
+		res.Buckets = append(res.Buckets, Bucket{Key: r.Name, Count: r.Count, Min: r.Min, Max: r.Max})
+	}
+
+	dates := append(search.DateRangeFacets{}, f.DateRanges...)
+	sort.SliceStable(dates, func(i, j int) bool {
+		// RFC 3339 timestamps in the same zone sort lexicographically
+		return lessBound(dates[i].Start, dates[j].Start)
+	})
+	for _, r := range dates {
+		res.Buckets = append(res.Buckets, Bucket{Key: r.Name, Count: r.Count, Start: r.Start, End: r.End})
+	}
+	return res
+}
+
+// lessBound orders lower bounds, a missing one is the smallest.
+func lessBound[T float64 | string](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return *a < *b
+}
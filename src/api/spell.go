@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxCorrections is the number of corrected queries offered.
+	maxCorrections = 3
+	// maxCandidates is the number of replacements considered for a word.
+	maxCandidates = 3
+	// checkDoneEvery is the number of dictionary terms compared between
+	// checks of the context.
+	checkDoneEvery = 1024
+)
+
+type candidate struct {
+	term     string
+	distance int
+	count    uint64
+}
+
+// DidYouMean offers corrected versions of the query, best first, built
+// from the terms of the given fields. Misspelled words are replaced by the
+// closest terms by edit distance, frequent terms win among equally close
+// ones. Words found in the dictionary are left as typed. The replacements
+// are indexed terms, so they are lower case and stemmed by the analyzer of
+// the field. Nothing is returned when there is nothing to correct. When ctx
+// is done, the corrections found so far are returned and the words not
+// looked up yet are left as typed.
+func DidYouMean(ctx context.Context, indexes []bleve.Index, text string, fields []string) ([]string, error) {
+	words := plainWords(text)
+	candidates := make([][]candidate, len(words))
+	corrected := false
+	for i, w := range words {
+		if ctx.Err() != nil {
+			break
+		}
+		c, err := correct(ctx, indexes, w, fields)
+		if err != nil {
+			return nil, err
+		}
+		candidates[i] = c
+		corrected = corrected || len(c) > 0
+	}
+	if !corrected {
+		return nil, nil
+	}
+
+	// the best replacement of every word first, then the runner-ups of the
+	// words one at a time
+	choice := make([]int, len(words))
+	res := []string{join(words, candidates, choice)}
+	for rank := 1; rank < maxCandidates; rank++ {
+		for i := range words {
+			if len(res) == maxCorrections {
+				return res, nil
+			}
+			if rank >= len(candidates[i]) {
+				continue
+			}
+			choice[i] = rank
+			res = append(res, join(words, candidates, choice))
+			choice[i] = 0
+		}
+	}
+	return res, nil
+}
+
+func join(words []string, candidates [][]candidate, choice []int) string {
+	res := make([]string, len(words))
+	for i, w := range words {
+		res[i] = w
+		if len(candidates[i]) > 0 {
+			res[i] = candidates[i][choice[i]].term
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+// correct returns the replacements of a misspelled word, none if the word
+// is spelled right or too short to tell. The dictionaries are only read
+// until ctx is done.
+func correct(ctx context.Context, indexes []bleve.Index, word string, fields []string) ([]candidate, error) {
+	max := fuzziness(word)
+	if max == 0 {
+		return nil, nil
+	}
+
+	found := map[string]*candidate{}
+	var d []int
+	compared := 0
+lookup:
+	for _, index := range indexes {
+		for _, field := range fields {
+			term := analyzeWord(index, field, word)
+			if term == "" {
+				continue
+			}
+			dict, err := index.FieldDict(field)
+			if err != nil {
+				return nil, err
+			}
+			for {
+				entry, err := dict.Next()
+				if err != nil {
+					dict.Close()
+					return nil, err
+				}
+				if entry == nil {
+					break
+				}
+				compared++
+				if compared%checkDoneEvery == 0 && ctx.Err() != nil {
+					dict.Close()
+					break lookup
+				}
+				var distance int
+				var exceeded bool
+				distance, exceeded, d = search.LevenshteinDistanceMaxReuseSlice(term, entry.Term, max, d)
+				if exceeded || distance > max {
+					continue
+				}
+				if distance == 0 {
+					dict.Close()
+					return nil, nil
+				}
+				c, ok := found[entry.Term]
+				if !ok {
+					c = &candidate{term: entry.Term, distance: distance}
+					found[entry.Term] = c
+				}
+				c.count += entry.Count
+			}
+			err = dict.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	res := make([]candidate, 0, len(found))
+	for _, c := range found {
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].distance != res[j].distance {
+			return res[i].distance < res[j].distance
+		}
+		if res[i].count != res[j].count {
+			return res[i].count > res[j].count
+		}
+		return res[i].term < res[j].term
+	})
+	if len(res) > maxCandidates {
+		res = res[:maxCandidates]
+	}
+	return res, nil
+}
+
+// analyzeWord returns the word as the field indexes it, so that it is
+// compared to terms in the same form.
+func analyzeWord(index bleve.Index, field, word string) string {
+	m := index.Mapping()
+	analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath(field))
+	if analyzer == nil {
+		return strings.ToLower(word)
+	}
+	tokens := analyzer.Analyze([]byte(word))
+	if len(tokens) == 0 {
+		// a stop word
+		return ""
+	}
+	return string(tokens[0].Term)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"reflect"
+	"testing"
+)
+
+// expiring is a context that runs out of time after a number of checks.
+type expiring struct {
+	context.Context
+	checks int
+}
+
+func (c *expiring) Err() error {
+	if c.checks == 0 {
+		return context.DeadlineExceeded
+	}
+	c.checks--
+	return nil
+}
+
+func TestDidYouMean(t *testing.T) {
+	index, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	// the dictionary is sorted, the terms before the right one make the
+	// lookup long enough to run out of time
+	batch := index.NewBatch()
+	for i := 0; i < 3*checkDoneEvery; i++ {
+		if err := batch.Index(fmt.Sprint(i), map[string]interface{}{"filmname": fmt.Sprintf("a%05d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Index("terminator", map[string]interface{}{"filmname": "terminator"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{name: "in time", ctx: context.Background(), want: []string{"terminator"}},
+		{name: "out of time", ctx: &expiring{Context: context.Background()}},
+		{name: "out of time in the dictionary", ctx: &expiring{Context: context.Background(), checks: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DidYouMean(tt.ctx, []bleve.Index{index}, "termintor", []string{"filmname"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
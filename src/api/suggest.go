@@ -0,0 +1,179 @@
+package api
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultSuggestSize is the number of completions returned when the
+	// request has no size.
+	DefaultSuggestSize = 10
+	MaxSuggestSize     = 50
+	// maxPrefixLength keeps out input that is clearly not typed by hand.
+	maxPrefixLength = 100
+)
+
+// SuggestRequest asks for completions of what the user has typed so far.
+type SuggestRequest struct {
+	Prefix string
+	Size   int
+}
+
+// ParseSuggest reads `prefix` and `size` from the query string.
+func ParseSuggest(req *http.Request) (*SuggestRequest, error) {
+	if req.Method != http.MethodGet {
+		return nil, &Error{Status: http.StatusMethodNotAllowed, Message: "suggest accepts only GET"}
+	}
+	q := req.URL.Query()
+	r := &SuggestRequest{Prefix: strings.TrimLeft(q.Get("prefix"), " "), Size: DefaultSuggestSize}
+	if strings.TrimSpace(r.Prefix) == "" {
+		return nil, badRequest("query string parameter 'prefix' is missing")
+	}
+	if utf8.RuneCountInString(r.Prefix) > maxPrefixLength {
+		return nil, badRequest("prefix must not be longer than %d characters", maxPrefixLength)
+	}
+	if v := q.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, badRequest("size must be an integer, got %q", v)
+		}
+		if size <= 0 || size > MaxSuggestSize {
+			return nil, badRequest("size must be between 1 and %d", MaxSuggestSize)
+		}
+		r.Size = size
+	}
+	return r, nil
+}
+
+// Query matches documents with a field value that starts with the prefix.
+// Words before the last one are complete and matched as usual, the last
+// one is still being typed and matched by prefix. The prefix is compared
+// to the analyzed terms, which are stemmed, so the last word is matched
+// as a whole word too: "москвы" doesn't prefix the term "москв".
+func (r *SuggestRequest) Query(field string) query.Query {
+	words := strings.Fields(strings.ToLower(r.Prefix))
+	last := ""
+	if !strings.HasSuffix(r.Prefix, " ") {
+		last, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	var conjuncts []query.Query
+	for _, w := range words {
+		m := bleve.NewMatchQuery(w)
+		m.SetField(field)
+		conjuncts = append(conjuncts, m)
+	}
+	if last != "" {
+		p := bleve.NewPrefixQuery(last)
+		p.SetField(field)
+		m := bleve.NewMatchQuery(last)
+		m.SetField(field)
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(p, m))
+	}
+	if len(conjuncts) == 1 {
+		return conjuncts[0]
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+// SearchRequest returns the request for completions from a single field.
+func (r *SuggestRequest) SearchRequest(field string) *bleve.SearchRequest {
+	// a value may repeat in many documents, take some extra to have
+	// enough left after removing duplicates
+	req := bleve.NewSearchRequestOptions(r.Query(field), r.Size*2, 0, false)
+	req.Fields = []string{field}
+	return req
+}
+
+type Suggestion struct {
+	Text  string `json:"text"`
+	Field string `json:"field"`
+	Index string `json:"index,omitempty"`
+
+	score float64
+}
+
+// SuggestResponse is what the suggest function returns.
+type SuggestResponse struct {
+	Version     int          `json:"version"`
+	Prefix      string       `json:"prefix"`
+	Suggestions []Suggestion `json:"suggestions"`
+	// Durations of the stages in microseconds.
+	Durations map[string]int64 `json:"durations"`
+}
+
+// Suggestions collects completions from the results of SearchRequest for
+// every field. They are ordered by score and the same text found in
+// several documents or fields is returned once.
+type Suggestions struct {
+	req  *SuggestRequest
+	list []Suggestion
+}
+
+func NewSuggestions(req *SuggestRequest) *Suggestions {
+	return &Suggestions{req: req}
+}
+
+func (s *Suggestions) Add(field string, res *bleve.SearchResult) {
+	for _, hit := range res.Hits {
+		for _, text := range fieldStrings(hit, field) {
+			s.list = append(s.list, Suggestion{Text: text, Field: field, Index: hit.Index, score: hit.Score})
+		}
+	}
+}
+
+func (s *Suggestions) Response(durations map[string]int64) *SuggestResponse {
+	sort.SliceStable(s.list, func(i, j int) bool {
+		return s.list[i].score > s.list[j].score
+	})
+	res := &SuggestResponse{
+		Version:     ResponseVersion,
+		Prefix:      s.req.Prefix,
+		Suggestions: []Suggestion{},
+		Durations:   durations,
+	}
+	seen := map[string]bool{}
+	for _, sg := range s.list {
+		key := strings.ToLower(sg.Text)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res.Suggestions = append(res.Suggestions, sg)
+		if len(res.Suggestions) == s.req.Size {
+			break
+		}
+	}
+	return res
+}
+
+// fieldStrings returns the non empty string values of a stored field,
+// which is a slice when the field has several values.
+func fieldStrings(hit *search.DocumentMatch, field string) []string {
+	var res []string
+	switch v := hit.Fields[field].(type) {
+	case string:
+		res = append(res, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+	}
+	n := 0
+	for _, s := range res {
+		if s = strings.TrimSpace(s); s != "" {
+			res[n] = s
+			n++
+		}
+	}
+	return res[:n]
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search/query"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// SyntaxQuery is the bleve query string syntax, the default.
+	SyntaxQuery = "query"
+	// SyntaxSimple takes the query as plain text, nothing in it has a
+	// special meaning and so it can't be malformed.
+	SyntaxSimple = "simple"
+)
+
+func validSyntax(s string) bool {
+	switch s {
+	case "", SyntaxQuery, SyntaxSimple:
+		return true
+	}
+	return false
+}
+
+// SyntaxError points at the part of a query string bleve can't parse.
+type SyntaxError struct {
+	// Position of the offending character, counted in characters from 1.
+	// It is one past the end of the query when the query ends too early.
+	Position int `json:"position"`
+	// Message explains the problem in English and Russian.
+	Message map[string]string `json:"message"`
+}
+
+func syntaxError(pos int, en, ru string) *Error {
+	return &Error{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("query syntax error at position %d: %v", pos, en),
+		Syntax: &SyntaxError{
+			Position: pos,
+			Message:  map[string]string{"en": en, "ru": ru},
+		},
+	}
+}
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokString
+	tokPhrase
+	tokNumber
+	tokPlus
+	tokMinus
+	tokColon
+	tokGreater
+	tokLess
+	tokEqual
+	tokBoost
+	tokTilde
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	// pos of the first character of the token, from 1.
+	pos int
+}
+
+// checkSyntax reports where the query string goes wrong. The query string
+// parser of bleve only tells that there is a syntax error, so the query is
+// tokenized the same way bleve's lexer does it and checked against the same
+// grammar. Bleve has the final word though, for anything the check missed.
+func checkSyntax(q string) error {
+	tokens, err := lexQuery([]rune(q))
+	if err != nil {
+		return err
+	}
+	p := &syntaxChecker{tokens: tokens}
+	err = p.check()
+	if err != nil {
+		return err
+	}
+	_, err = query.NewQueryStringQuery(q).Parse()
+	if err != nil {
+		return syntaxError(1,
+			fmt.Sprintf("query can't be parsed: %v", err),
+			fmt.Sprintf("не удалось разобрать запрос: %v", err))
+	}
+	return nil
+}
+
+// lexQuery mirrors the lexer of the bleve query string, quirks included:
+// only a space ends a term, `:`, `^` and `~` end terms but not numbers and
+// a backslash escapes the character after it.
+func lexQuery(q []rune) ([]token, error) {
+	var tokens []token
+	i := 0
+	// scan reads up to the first unescaped rune stop tells, unescaping the
+	// rest.
+	scan := func(stop func(rune) bool) string {
+		var sb strings.Builder
+		for ; i < len(q); i++ {
+			if q[i] == '\\' && i+1 < len(q) {
+				i++
+				sb.WriteString(unescape(q[i]))
+				continue
+			}
+			if q[i] == '\\' {
+				continue
+			}
+			if stop(q[i]) {
+				break
+			}
+			sb.WriteRune(q[i])
+		}
+		return sb.String()
+	}
+	isSpace := func(r rune) bool { return r == ' ' }
+
+	for i < len(q) {
+		r, start := q[i], i+1
+		switch r {
+		case '"':
+			i++
+			value := scan(func(r rune) bool { return r == '"' })
+			if i == len(q) {
+				return nil, syntaxError(start, "unterminated quote", "кавычка не закрыта")
+			}
+			i++
+			tokens = append(tokens, token{typ: tokPhrase, value: value, pos: start})
+			continue
+		case '+', '-', ':', '>', '<', '=':
+			i++
+			tokens = append(tokens, token{typ: singleCharTokens[r], value: string(r), pos: start})
+			continue
+		case '^', '~':
+			i++
+			value := scan(isSpace)
+			if value == "" {
+				value = "1"
+			}
+			typ := tokBoost
+			if r == '~' {
+				typ = tokTilde
+			}
+			tokens = append(tokens, token{typ: typ, value: value, pos: start})
+			continue
+		}
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+		if r == '\\' && i+1 == len(q) {
+			// bleve drops a trailing backslash
+			break
+		}
+
+		var sb strings.Builder
+		if unicode.IsDigit(r) {
+			// a number turns into a term at the first character that is not
+			// a digit or the only dot, which is taken into the term as is
+			end, dot := i, false
+			for end < len(q) && (unicode.IsDigit(q[end]) || q[end] == '.' && !dot) {
+				dot = dot || q[end] == '.'
+				end++
+			}
+			if end == len(q) || q[end] == ' ' || q[end] == '\\' && end+1 == len(q) {
+				tokens = append(tokens, token{typ: tokNumber, value: string(q[i:end]), pos: start})
+				i = end + 1
+				continue
+			}
+			sb.WriteString(string(q[i:end]))
+			if q[end] == '\\' {
+				end++
+				sb.WriteString(unescape(q[end]))
+			} else {
+				sb.WriteRune(q[end])
+			}
+			i = end + 1
+		}
+		sb.WriteString(scan(func(r rune) bool {
+			return r == ' ' || r == ':' || r == '^' || r == '~'
+		}))
+		tokens = append(tokens, token{typ: tokString, value: sb.String(), pos: start})
+	}
+	return append(tokens, token{typ: tokEOF, pos: len(q) + 1}), nil
+}
+
+var singleCharTokens = map[rune]tokenType{
+	'+': tokPlus,
+	'-': tokMinus,
+	':': tokColon,
+	'>': tokGreater,
+	'<': tokLess,
+	'=': tokEqual,
+}
+
+// unescape keeps the backslash in front of characters that don't need
+// escaping, as bleve does.
+func unescape(r rune) string {
+	if strings.ContainsRune(reservedChars, r) {
+		return string(r)
+	}
+	return `\` + string(r)
+}
+
+const reservedChars = "+-=&|><!(){}[]^\"~*?:\\/ "
+
+// syntaxChecker follows the grammar of the bleve query string:
+//
+//	query  = part {part}
+//	part   = ["+" | "-"] base ["^" boost]
+//	base   = term ["~" fuzziness] | number | phrase
+//	       | term ":" (term ["~" fuzziness] | phrase | ["-"] number
+//	                   | (">" | "<") ["="] (["-"] number | phrase))
+type syntaxChecker struct {
+	tokens []token
+	i      int
+}
+
+func (p *syntaxChecker) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *syntaxChecker) next() token {
+	t := p.tokens[p.i]
+	if t.typ != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *syntaxChecker) check() error {
+	for {
+		err := p.part()
+		if err != nil {
+			return err
+		}
+		if p.peek().typ == tokEOF {
+			return nil
+		}
+	}
+}
+
+func (p *syntaxChecker) part() error {
+	if t := p.peek(); t.typ == tokPlus || t.typ == tokMinus {
+		p.next()
+	}
+	err := p.base()
+	if err != nil {
+		return err
+	}
+	if t := p.peek(); t.typ == tokBoost {
+		p.next()
+		if _, err := strconv.ParseFloat(t.value, 64); err != nil {
+			return syntaxError(t.pos,
+				fmt.Sprintf("boost after ^ must be a number, got %q", t.value),
+				fmt.Sprintf("вес после ^ должен быть числом, а не %q", t.value))
+		}
+	}
+	return nil
+}
+
+func (p *syntaxChecker) base() error {
+	t := p.next()
+	switch t.typ {
+	case tokNumber, tokPhrase:
+		return nil
+	case tokString:
+	default:
+		return unexpected(t)
+	}
+	if p.peek().typ != tokColon {
+		return p.term(t)
+	}
+	field := t.value
+	p.next()
+
+	t = p.next()
+	switch t.typ {
+	case tokString:
+		return p.term(t)
+	case tokPhrase, tokNumber:
+		return nil
+	case tokMinus:
+		return p.number(t.value)
+	case tokGreater, tokLess:
+		op := t.value
+		if p.peek().typ == tokEqual {
+			op += p.next().value
+		}
+		if p.peek().typ == tokPhrase {
+			return p.date(p.next())
+		}
+		if p.peek().typ == tokMinus {
+			p.next()
+		}
+		return p.number(op)
+	case tokEOF:
+		return syntaxError(t.pos,
+			fmt.Sprintf("field %v has no value after the colon", field),
+			fmt.Sprintf("у поля %v нет значения после двоеточия", field))
+	}
+	return unexpected(t)
+}
+
+// term is either fuzzy, if ~ follows, or a plain, wildcard or regexp term.
+func (p *syntaxChecker) term(term token) error {
+	t := p.peek()
+	if t.typ != tokTilde {
+		if term.value == "/" {
+			// bleve takes it for a regexp and fails to cut the slashes off
+			return syntaxError(term.pos,
+				"a single / is not a regular expression",
+				"одиночный / не является регулярным выражением")
+		}
+		return nil
+	}
+	p.next()
+	if _, err := strconv.ParseFloat(t.value, 64); err != nil {
+		return syntaxError(t.pos,
+			fmt.Sprintf("fuzziness after ~ must be a number, got %q", t.value),
+			fmt.Sprintf("нечёткость после ~ должна быть числом, а не %q", t.value))
+	}
+	return nil
+}
+
+// number must follow the operator op.
+func (p *syntaxChecker) number(op string) error {
+	t := p.next()
+	if t.typ == tokNumber {
+		return nil
+	}
+	if op == "-" {
+		return syntaxError(t.pos,
+			"a number is expected after the minus",
+			"после минуса ожидается число")
+	}
+	return syntaxError(t.pos,
+		fmt.Sprintf("a number or a date in quotes is expected after %v", op),
+		fmt.Sprintf("после %v ожидается число или дата в кавычках", op))
+}
+
+func (p *syntaxChecker) date(t token) error {
+	parser, err := registry.NewCache().DateTimeParserNamed(query.QueryDateTimeParser)
+	if err != nil {
+		return err
+	}
+	if _, err := parser.ParseDateTime(t.value); err != nil {
+		return syntaxError(t.pos,
+			fmt.Sprintf("%q is not a date", t.value),
+			fmt.Sprintf("%q не является датой", t.value))
+	}
+	return nil
+}
+
+func unexpected(t token) error {
+	if t.typ == tokEOF {
+		return syntaxError(t.pos, "unexpected end of query", "неожиданный конец запроса")
+	}
+	return syntaxError(t.pos,
+		fmt.Sprintf("unexpected %q", t.value),
+		fmt.Sprintf("неожиданный символ %q", t.value))
+}
+
+// escapeQuery turns text into a query string matching it as plain text.
+// Wildcards and slashes keep their meaning in bleve even when escaped, so
+// they are replaced with spaces, the analyzer would drop them anyway.
+func escapeQuery(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '*' || r == '?' || r == '/':
+			sb.WriteRune(' ')
+		case r != ' ' && strings.ContainsRune(reservedChars, r):
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
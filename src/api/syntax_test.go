@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"github.com/blevesearch/bleve/search/query"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	tests := []struct {
+		query string
+		// position and message are empty for a valid query
+		position int
+		message  string
+	}{
+		{query: "terminator"},
+		{query: "filmname:terminator"},
+		{query: `+james -cameron^2 termintor~1 "the end"`},
+		{query: "crYearOfProduction:>=1984"},
+		{query: `added:<"2001-02-03T04:05:06Z"`},
+		{query: `term\:inator`},
+		{query: "filmname:", position: 10, message: "field filmname has no value after the colon"},
+		{query: "фильм:", position: 7, message: "field фильм has no value after the colon"},
+		{query: `"terminator`, position: 1, message: "unterminated quote"},
+		{query: `james "the end`, position: 7, message: "unterminated quote"},
+		{query: "terminator^x", position: 11, message: `boost after ^ must be a number, got "x"`},
+		{query: "terminator~x", position: 11, message: `fuzziness after ~ must be a number, got "x"`},
+		{query: "year:>abc", position: 7, message: "a number or a date in quotes is expected after >"},
+		{query: "year:<=", position: 8, message: "a number or a date in quotes is expected after <="},
+		{query: "year:-abc", position: 7, message: "a number is expected after the minus"},
+		{query: `added:>"garbage"`, position: 8, message: `"garbage" is not a date`},
+		{query: "/", position: 1, message: "a single / is not a regular expression"},
+		{query: "+", position: 2, message: "unexpected end of query"},
+		{query: ":cameron", position: 1, message: `unexpected ":"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			err := checkSyntax(tt.query)
+			if tt.message == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Syntax == nil {
+				t.Fatalf("got %v, want a syntax error", err)
+			}
+			if e.Syntax.Position != tt.position || e.Syntax.Message["en"] != tt.message {
+				t.Errorf("got %q at %d, want %q at %d", e.Syntax.Message["en"], e.Syntax.Position, tt.message, tt.position)
+			}
+			if e.Syntax.Message["ru"] == "" {
+				t.Error("no message in Russian")
+			}
+		})
+	}
+}
+
+// randomQuery makes up a short query from the characters that mean
+// something to the query string syntax and a few that don't.
+func randomQuery(rnd *rand.Rand, chars []rune) string {
+	q := make([]rune, 1+rnd.Intn(10))
+	for i := range q {
+		q[i] = chars[rnd.Intn(len(chars))]
+	}
+	return string(q)
+}
+
+// TestCheckSyntaxMatchesBleve keeps the checker in line with the parser of
+// bleve, which it mirrors. A query the checker rejects and bleve parses is
+// a false bad request, the other way round the position is lost.
+func TestCheckSyntaxMatchesBleve(t *testing.T) {
+	n := 200000
+	if testing.Short() {
+		n = 10000
+	}
+	rnd := rand.New(rand.NewSource(1))
+	chars := []rune(`ab1.ф +-:><=^~"\/*?`)
+	for i := 0; i < n; i++ {
+		q := randomQuery(rnd, chars)
+		tokens, checked := lexQuery([]rune(q))
+		if checked == nil {
+			checked = (&syntaxChecker{tokens: tokens}).check()
+		}
+		_, parsed := query.NewQueryStringQuery(q).Parse()
+		if (checked == nil) != (parsed == nil) {
+			t.Errorf("%q: checked %v, parsed by bleve %v", q, checked, parsed)
+		}
+	}
+}
+
+// TestEscapeQuery checks that any text escaped for the simple syntax either
+// parses or is blank and matches nothing.
+func TestEscapeQuery(t *testing.T) {
+	n := 200000
+	if testing.Short() {
+		n = 10000
+	}
+	rnd := rand.New(rand.NewSource(1))
+	chars := []rune("ab1.ф\t&|!(){}[]" + reservedChars)
+	for i := 0; i < n; i++ {
+		text := randomQuery(rnd, chars)
+		escaped := escapeQuery(text)
+		if strings.TrimSpace(escaped) == "" {
+			r := &SearchRequest{Query: text, Syntax: SyntaxSimple}
+			if _, ok := r.query(Defaults{}, ModeExact).(*query.MatchNoneQuery); !ok && strings.TrimSpace(text) != "" {
+				t.Errorf("%q: blank after escaping but matches something", text)
+			}
+			continue
+		}
+		if _, err := query.NewQueryStringQuery(escaped).Parse(); err != nil {
+			t.Errorf("%q: escaped to %q, which bleve can't parse: %v", text, escaped, err)
+		}
+		if err := checkSyntax(escaped); err != nil {
+			t.Errorf("%q: escaped to %q, which is rejected: %v", text, escaped, err)
+		}
+	}
+}
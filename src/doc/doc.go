@@ -0,0 +1,80 @@
+// Package doc holds the documents we index. The parsers in cmd write them
+// and the search function reads them back from the stored fields of hits.
+package doc
+
+import (
+	"encoding/json"
+)
+
+// TypeField is the field with the document type, it is the type_field of
+// the index mappings.
+const TypeField = "_type"
+
+const (
+	TypeFilm = "film"
+	TypeJoke = "joke"
+)
+
+// Film is returned with only the fields the request asked for, the rest is
+// left out rather than zero. Numbers are pointers, a zero year or age limit
+// is a value of its own.
+type Film struct {
+	DocType             string `json:"_type"`
+	ForeignName         string `json:"foreignName,omitempty"`
+	Filmname            string `json:"filmname,omitempty"`
+	Studio              string `json:"studio,omitempty"`
+	CrYearOfProduction  *int   `json:"crYearOfProduction,omitempty"`
+	Director            string `json:"director,omitempty"`
+	ScriptAuthor        string `json:"scriptAuthor,omitempty"`
+	Composer            string `json:"composer,omitempty"`
+	Cameraman           string `json:"cameraman,omitempty"`
+	Producer            string `json:"producer,omitempty"`
+	Duration            *int   `json:"duration,omitempty"`
+	Color               string `json:"color,omitempty"`
+	Annotation          string `json:"annotation,omitempty"`
+	CountryOfProduction string `json:"countryOfProduction,omitempty"`
+	Category            string `json:"category,omitempty"`
+	AgeLimit            *int   `json:"ageLimit,omitempty"`
+}
+
+type Joke struct {
+	DocType string `json:"_type"`
+	Joke    string `json:"joke,omitempty"`
+}
+
+// Decode turns the stored fields of a hit into the document of its type.
+// Documents without the type field are taken to be of defaultType. Fields
+// of unknown types, or ones that don't fit the type, are returned as is.
+func Decode(fields map[string]interface{}, defaultType string) (string, interface{}) {
+	docType, _ := fields[TypeField].(string)
+	if docType == "" {
+		docType = defaultType
+	}
+
+	var d interface{}
+	switch docType {
+	case TypeFilm:
+		d = &Film{}
+	case TypeJoke:
+		d = &Joke{}
+	default:
+		return docType, fields
+	}
+
+	// stored fields are already JSON-shaped: strings, float64 and slices
+	// of them for repeated values
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return docType, fields
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return docType, fields
+	}
+	switch d := d.(type) {
+	case *Film:
+		d.DocType = docType
+	case *Joke:
+		d.DocType = docType
+	}
+	return docType, d
+}
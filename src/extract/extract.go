@@ -0,0 +1,306 @@
+// Package extract unpacks index archives without trusting their content:
+// entries can't be written outside of the destination directory, symlinks
+// can't point outside of it and the amount of extracted data is limited.
+package extract
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mholt/archiver/v4"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	ErrAbsolutePath      = errors.New("absolute path in archive")
+	ErrPathTraversal     = errors.New("path escapes destination directory")
+	ErrUnsafeLink        = errors.New("link escapes destination directory")
+	ErrIrregularFile     = errors.New("unsupported file type")
+	ErrTooManyFiles      = errors.New("too many files in archive")
+	ErrFileTooLarge      = errors.New("file too large")
+	ErrArchiveTooLarge   = errors.New("archive too large")
+)
+
+// Error tells which entry of the archive was rejected. Err is one of the
+// Err* variables above or an I/O error.
+type Error struct {
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("extract %v: %v", e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Limits bound what a single archive may unpack to. Zero means no limit.
+type Limits struct {
+	MaxFiles     int
+	MaxFileSize  int64
+	MaxTotalSize int64
+}
+
+// DefaultLimits are generous for a bleve index and still fit into the
+// memory backed /tmp of a cloud function.
+var DefaultLimits = Limits{
+	MaxFiles:     10000,
+	MaxFileSize:  512 << 20,
+	MaxTotalSize: 1 << 30,
+}
+
+// Extract unpacks the archive read from r into root, which must exist.
+// name is only used to detect the archive format. Extraction stops at the
+// first rejected entry, what was extracted so far is left in root.
+func Extract(ctx context.Context, name string, r io.Reader, root string, limits Limits) error {
+	format, input, err := archiver.Identify(name, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrUnsupportedFormat, name, err)
+	}
+	ex, ok := format.(archiver.Extractor)
+	if !ok {
+		return fmt.Errorf("%w: %v can't be extracted", ErrUnsupportedFormat, name)
+	}
+
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	// links are resolved against the real root, root itself may be under
+	// a symlink, like /tmp is on some systems
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	x := &extractor{root: realRoot, limits: limits}
+	return ex.Extract(ctx, input, nil, func(ctx context.Context, f archiver.File) error {
+		err := x.handle(f)
+		if err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				err = &Error{Name: f.NameInArchive, Err: err}
+			}
+		}
+		return err
+	})
+}
+
+type extractor struct {
+	root   string
+	limits Limits
+	files  int
+	total  int64
+}
+
+func (x *extractor) handle(f archiver.File) error {
+	name, err := x.clean(f.NameInArchive)
+	if err != nil {
+		return err
+	}
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return ErrTooManyFiles
+	}
+
+	outputPath := filepath.Join(x.root, filepath.FromSlash(name))
+	if f.IsDir() {
+		return x.mkdir(outputPath)
+	}
+	err = x.mkdir(filepath.Dir(outputPath))
+	if err != nil {
+		return err
+	}
+
+	if f.LinkTarget != "" {
+		return x.link(f, name, outputPath)
+	}
+	if !f.Mode().IsRegular() {
+		return ErrIrregularFile
+	}
+
+	// a symlink left by an earlier entry would be followed when the file is
+	// opened
+	if fi, err := os.Lstat(outputPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return ErrUnsafeLink
+	}
+
+	reader, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm()|0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %v", outputPath, err)
+	}
+	defer writer.Close()
+
+	// the size in the header is not trusted, the limits are checked against
+	// what is actually written
+	limit := int64(-1)
+	if x.limits.MaxFileSize > 0 {
+		limit = x.limits.MaxFileSize
+	}
+	if x.limits.MaxTotalSize > 0 && (limit < 0 || x.limits.MaxTotalSize-x.total < limit) {
+		limit = x.limits.MaxTotalSize - x.total
+	}
+	var src io.Reader = reader
+	if limit >= 0 {
+		src = io.LimitReader(reader, limit+1)
+	}
+	n, err := io.Copy(writer, src)
+	x.total += n
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", outputPath, err)
+	}
+	if limit >= 0 && n > limit {
+		if x.limits.MaxFileSize > 0 && n > x.limits.MaxFileSize {
+			return ErrFileTooLarge
+		}
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+// clean validates the name of an entry and returns it relative to root.
+func (x *extractor) clean(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", ErrAbsolutePath
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", ErrPathTraversal
+		}
+	}
+	name = path.Clean(name)
+	if name == "." {
+		return "", ErrPathTraversal
+	}
+	return name, nil
+}
+
+// link creates symbolic and hard links. Targets of symlinks are relative to
+// the link itself, targets of tar hard links are relative to the archive root.
+// Either way the target is resolved through the links extracted before it.
+func (x *extractor) link(f archiver.File, name, outputPath string) error {
+	target := filepath.FromSlash(strings.ReplaceAll(f.LinkTarget, `\`, "/"))
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return ErrUnsafeLink
+	}
+
+	if hdr, ok := f.Header.(*tar.Header); ok && hdr.Typeflag == tar.TypeLink {
+		resolved, _, err := x.resolve(x.root, target, 0)
+		if err != nil {
+			return err
+		}
+		return os.Link(resolved, outputPath)
+	}
+
+	// mkdir has made sure the directory is within root
+	dir, err := filepath.EvalSymlinks(filepath.Dir(outputPath))
+	if err != nil {
+		return err
+	}
+	_, _, err = x.resolve(dir, target, 0)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, outputPath)
+}
+
+// maxLinkDepth is how many links may be followed resolving a single target,
+// as ELOOP does in the kernel.
+const maxLinkDepth = 40
+
+// resolve follows target from dir the way the kernel would and fails if it
+// leaves root at any point. Parts of the target that don't exist yet are
+// taken as they are, but no ".." may follow them: a link extracted later in
+// their place could send it anywhere. missing tells whether the result
+// doesn't exist.
+func (x *extractor) resolve(dir, target string, depth int) (resolved string, missing bool, err error) {
+	if depth > maxLinkDepth {
+		return "", false, ErrUnsafeLink
+	}
+	resolved = dir
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		switch {
+		case part == "" || part == ".":
+			continue
+		case part == ".." && missing:
+			return "", false, ErrUnsafeLink
+		case part == "..":
+			resolved = filepath.Dir(resolved)
+			if !x.within(resolved) {
+				return "", false, ErrUnsafeLink
+			}
+			continue
+		case missing:
+			resolved = filepath.Join(resolved, part)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(next)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			resolved, missing = next, true
+		case err != nil:
+			return "", false, err
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(next)
+			if err != nil {
+				return "", false, err
+			}
+			if filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+				return "", false, ErrUnsafeLink
+			}
+			resolved, missing, err = x.resolve(resolved, link, depth+1)
+			if err != nil {
+				return "", false, err
+			}
+		default:
+			resolved = next
+		}
+	}
+	return resolved, missing, nil
+}
+
+// mkdir creates dir and makes sure that it didn't end up outside of root by
+// following a symlink created by an earlier entry.
+func (x *extractor) mkdir(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %v: %v", dir, err)
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !x.within(real) {
+		return ErrPathTraversal
+	}
+	return nil
+}
+
+func (x *extractor) within(p string) bool {
+	return isWithin(x.root, p)
+}
+
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
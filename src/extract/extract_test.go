@@ -0,0 +1,291 @@
+package extract_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/nikolaymatrosov/go-sls-search/extract"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name string
+	typ  byte
+	link string
+	body string
+}
+
+func file(name, body string) entry {
+	return entry{name: name, typ: tar.TypeReg, body: body}
+}
+
+func dir(name string) entry {
+	return entry{name: name, typ: tar.TypeDir}
+}
+
+func symlink(name, target string) entry {
+	return entry{name: name, typ: tar.TypeSymlink, link: target}
+}
+
+func hardlink(name, target string) entry {
+	return entry{name: name, typ: tar.TypeLink, link: target}
+}
+
+func archive(t *testing.T, entries ...entry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Linkname: e.link,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// sandbox returns the destination directory inside a directory that must
+// stay empty otherwise.
+func sandbox(t *testing.T) (string, string) {
+	t.Helper()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return parent, root
+}
+
+func TestExtract(t *testing.T) {
+	parent, root := sandbox(t)
+	err := extract.Extract(context.Background(), "index.tar", archive(t,
+		dir("index"),
+		file("index/store/root.bolt", "bolt"),
+		file("index/index_meta.json", "{}"),
+		symlink("current", "index"),
+		symlink("index/store/meta", "../index_meta.json"),
+		hardlink("copy.json", "index/index_meta.json"),
+	), root, extract.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"index/store/root.bolt":   "bolt",
+		"current/index_meta.json": "{}",
+		"index/store/meta":        "{}",
+		"copy.json":               "{}",
+	} {
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%v = %q, want %q", name, got, want)
+		}
+	}
+	assertOnlyRoot(t, parent)
+}
+
+func TestExtractRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  extract.Limits
+		// entry is the name of the rejected entry
+		entry string
+		err   error
+	}{
+		{
+			name:    "absolute path",
+			entries: []entry{file("/pwned", "owned")},
+			entry:   "/pwned",
+			err:     extract.ErrAbsolutePath,
+		},
+		{
+			name:    "parent directory",
+			entries: []entry{file("../pwned", "owned")},
+			entry:   "../pwned",
+			err:     extract.ErrPathTraversal,
+		},
+		{
+			name:    "parent directory in the middle",
+			entries: []entry{file("index/../../pwned", "owned")},
+			entry:   "index/../../pwned",
+			err:     extract.ErrPathTraversal,
+		},
+		{
+			name:    "backslashes",
+			entries: []entry{file(`..\pwned`, "owned")},
+			entry:   `..\pwned`,
+			err:     extract.ErrPathTraversal,
+		},
+		{
+			name:    "symlink to parent",
+			entries: []entry{symlink("up", "..")},
+			entry:   "up",
+			err:     extract.ErrUnsafeLink,
+		},
+		{
+			name:    "absolute symlink",
+			entries: []entry{symlink("etc", "/etc")},
+			entry:   "etc",
+			err:     extract.ErrUnsafeLink,
+		},
+		{
+			name:    "hard link outside",
+			entries: []entry{hardlink("passwd", "../pwned")},
+			entry:   "passwd",
+			err:     extract.ErrUnsafeLink,
+		},
+		{
+			name: "symlink through a symlink",
+			entries: []entry{
+				symlink("sub", "."),
+				symlink("sub/p", "../pwned"),
+				file("p", "owned"),
+			},
+			entry: "sub/p",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			name: "parent of a symlinked directory",
+			entries: []entry{
+				dir("x"),
+				symlink("x/d", ".."),
+				symlink("l", "x/d/../pwned"),
+			},
+			entry: "l",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			name: "parent of a missing directory",
+			entries: []entry{
+				symlink("l", "a/../../pwned"),
+			},
+			entry: "l",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			// a could later become a link to a directory anywhere
+			name: "parent of a directory that may be a link later",
+			entries: []entry{
+				symlink("l", "a/.."),
+			},
+			entry: "l",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			name: "hard link through a symlink",
+			entries: []entry{
+				dir("x"),
+				symlink("x/d", ".."),
+				hardlink("h", "x/d/../pwned"),
+			},
+			entry: "h",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			name: "directory through a symlink",
+			entries: []entry{
+				symlink("x", "."),
+				dir("x/../../pwned"),
+			},
+			entry: "x/../../pwned",
+			err:   extract.ErrPathTraversal,
+		},
+		{
+			name: "file over a symlink",
+			entries: []entry{
+				file("f", "data"),
+				symlink("l", "f"),
+				file("l", "owned"),
+			},
+			entry: "l",
+			err:   extract.ErrUnsafeLink,
+		},
+		{
+			name:    "fifo",
+			entries: []entry{{name: "fifo", typ: tar.TypeFifo}},
+			entry:   "fifo",
+			err:     extract.ErrIrregularFile,
+		},
+		{
+			name:    "too many files",
+			entries: []entry{file("a", "a"), file("b", "b"), file("c", "c")},
+			limits:  extract.Limits{MaxFiles: 2},
+			entry:   "c",
+			err:     extract.ErrTooManyFiles,
+		},
+		{
+			name:    "file too large",
+			entries: []entry{file("a", "0123456789")},
+			limits:  extract.Limits{MaxFileSize: 4},
+			entry:   "a",
+			err:     extract.ErrFileTooLarge,
+		},
+		{
+			name:    "archive too large",
+			entries: []entry{file("a", "012345"), file("b", "012345")},
+			limits:  extract.Limits{MaxFileSize: 8, MaxTotalSize: 10},
+			entry:   "b",
+			err:     extract.ErrArchiveTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, root := sandbox(t)
+			err := extract.Extract(context.Background(), "index.tar", archive(t, tt.entries...), root, tt.limits)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			var e *extract.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("got %T, want *extract.Error", err)
+			}
+			if e.Name != tt.entry {
+				t.Errorf("rejected %q, want %q", e.Name, tt.entry)
+			}
+			assertOnlyRoot(t, parent)
+		})
+	}
+}
+
+func TestExtractUnsupportedFormat(t *testing.T) {
+	_, root := sandbox(t)
+	err := extract.Extract(context.Background(), "index.bin", bytes.NewBufferString("not an archive"), root, extract.DefaultLimits)
+	if !errors.Is(err, extract.ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want %v", err, extract.ErrUnsupportedFormat)
+	}
+}
+
+// assertOnlyRoot fails if anything was written next to the destination.
+func assertOnlyRoot(t *testing.T, parent string) {
+	t.Helper()
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "root" {
+			t.Errorf("%v was written outside of the destination", e.Name())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package manifest describes a published index archive. The manifest is
+// uploaded next to the archive by cmd/indexer and lets the search function
+// tell whether the index it serves is still current without downloading
+// the whole archive.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the manifest inside an extracted index directory.
+const FileName = "manifest.json"
+
+type Manifest struct {
+	// Version identifies the build. Two archives with the same version are
+	// considered identical.
+	Version   string    `json:"version"`
+	BuildTime time.Time `json:"buildTime"`
+	DocCount  uint64    `json:"docCount"`
+	// SHA256 is the hex encoded checksum of the archive.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// KeyFor returns the name of the manifest published for the archive,
+// e.g. film/index.manifest.json for film/index.tar.zst.
+func KeyFor(archive string) string {
+	dir, base := path.Split(archive)
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+	return dir + base + ".manifest.json"
+}
+
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	err := json.NewDecoder(r).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if m.Version == "" {
+		return nil, fmt.Errorf("manifest has no version")
+	}
+	return m, nil
+}
+
+func ReadFile(filename string) (*Manifest, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func (m *Manifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func (m *Manifest) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = m.Write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,522 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes where an index comes from and how it is served.
+// It is read from the environment once per container, see getIndexes.
+type Config struct {
+	// Name identifies the index in requests.
+	Name string
+	// Dataset is the kind of documents in the index, films or anek, which
+	// the defaults of the fields and facets are taken from. It defaults to
+	// the name of the index.
+	Dataset string
+	// Source selects the IndexSource implementation: s3, local or http.
+	Source string
+	// Bucket and Key locate the index archive in the object storage.
+	Bucket string
+	Key    string
+	// LocalPath is the archive or index directory used by the local source.
+	LocalPath string
+	// URL of the archive used by the http source.
+	URL string
+	// CacheDir is where the archive is extracted; the index itself ends up
+	// in CacheDir/index. Every index needs a directory of its own.
+	CacheDir string
+	// Endpoint and Region of the S3 compatible storage.
+	Endpoint string
+	Region   string
+	// DownloadTimeout limits fetching the archive on a cold start.
+	DownloadTimeout time.Duration
+	// FetchWait is how long a request waits for the index downloaded on a
+	// cold start before it fails.
+	FetchWait time.Duration
+	// ManifestTTL is how often a warm container checks whether a new
+	// version of the index was published. Zero disables the checks.
+	ManifestTTL time.Duration
+	// Fields are the stored fields returned with every hit.
+	Fields []string
+	// Profile weights the fields searched by plain text queries.
+	Profile api.Profile
+	// FuzzyFields are searched by typo tolerant queries and looked up for
+	// spelling corrections.
+	FuzzyFields []string
+	// SuggestFields are completed by the suggest endpoint, none means the
+	// index is not completed.
+	SuggestFields []string
+	// MaxSize is the largest page of hits a client may ask for.
+	MaxSize int
+	// Facets are computed when the request doesn't ask for specific ones.
+	// They can also be requested by name.
+	Facets map[string]*api.Facet
+}
+
+// dataset holds the defaults for the indexes of one of the datasets in
+// data/. An index of any other dataset has to configure its fields itself.
+type dataset struct {
+	fields        []string
+	fuzzyFields   []string
+	suggestFields []string
+	facets        string
+	// profile ranks plain text queries, none searches the default field.
+	profile string
+}
+
+var datasets = map[string]dataset{
+	"films": {
+		fields: []string{
+			"foreignName",
+			"filmname",
+			"studio",
+			"crYearOfProduction",
+			"director",
+			"scriptAuthor",
+			"composer",
+			"cameraman",
+			"producer",
+			"duration",
+			"color",
+			"annotation",
+			"countryOfProduction",
+			"category",
+			"ageLimit",
+		},
+		fuzzyFields:   []string{"filmname", "foreignName", "director"},
+		suggestFields: []string{"filmname", "foreignName", "director"},
+		// films by decade of production and by top countries
+		facets: `{
+			"year": {"field": "crYearOfProduction", "type": "numeric", "interval": 10},
+			"country": {"field": "countryOfProduction", "size": 5}
+		}`,
+		profile: DefaultProfile,
+	},
+	"anek": {
+		fields:      []string{"joke"},
+		fuzzyFields: []string{"joke"},
+		// a joke is too long to complete a prefix to
+		suggestFields: nil,
+		facets:        `{}`,
+		profile:       "none",
+	},
+}
+
+// DefaultProfile is the profile of film indexes unless SEARCH_PROFILE is
+// set. It ranks title matches first, then names and only then the long
+// annotation.
+const DefaultProfile = "filmname^4,foreignName^3,director^2,annotation"
+
+// Indexes are all the indexes served by one deployment.
+type Indexes struct {
+	// Names lists the indexes in the order of SEARCH_INDEXES. The first one
+	// is served when the request doesn't name an index.
+	Names   []string
+	Configs map[string]*Config
+	// Aliases search several indexes at once, e.g. {"all": ["films", "anek"]}.
+	Aliases map[string][]string
+	// RequestBudget is how long a request may take. It has to leave time
+	// to write the response before the function times out.
+	RequestBudget time.Duration
+}
+
+var (
+	configOnce sync.Once
+	configVal  *Indexes
+	configErr  error
+)
+
+// getIndexes returns the configuration loaded from the environment. The
+// environment doesn't change during the life of a container, so it is
+// parsed only once.
+func getIndexes() (*Indexes, error) {
+	configOnce.Do(func() {
+		configVal, configErr = loadIndexes(os.Getenv)
+	})
+	return configVal, configErr
+}
+
+// Configure loads the configuration from getenv instead of the environment,
+// which lets a caller such as cmd/server override some of the variables. It
+// must be called before the handlers serve the first request.
+func Configure(getenv func(string) string) error {
+	indexes, err := loadIndexes(getenv)
+	if err != nil {
+		return err
+	}
+	configOnce.Do(func() {})
+	configVal, configErr = indexes, nil
+	return nil
+}
+
+// loadIndexes reads SEARCH_INDEXES and the configuration of every index
+// listed there. Every SEARCH_* variable can be set for a single index as
+// SEARCH_<NAME>_*, e.g. SEARCH_ANEK_KEY, otherwise the shared one applies.
+// Fields and facets that aren't set default to those of the dataset of the
+// index, see datasets.
+func loadIndexes(getenv func(string) string) (*Indexes, error) {
+	names := splitList(getenv("SEARCH_INDEXES"))
+	if len(names) == 0 {
+		names = []string{"films"}
+	}
+
+	indexes := &Indexes{
+		Names:   names,
+		Configs: map[string]*Config{},
+		Aliases: map[string][]string{},
+	}
+	for _, name := range names {
+		if !validName(name) {
+			return nil, fmt.Errorf("SEARCH_INDEXES: invalid index name %q", name)
+		}
+		if indexes.Configs[name] != nil {
+			return nil, fmt.Errorf("SEARCH_INDEXES: index %v is listed twice", name)
+		}
+		cfg, err := loadConfig(name, getenv)
+		if err != nil {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("index %v: %v", name, err)
+			}
+			return nil, err
+		}
+		indexes.Configs[name] = cfg
+	}
+
+	budget := strings.TrimSpace(getenv("SEARCH_REQUEST_BUDGET"))
+	if budget == "" {
+		budget = "4s"
+	}
+	var err error
+	indexes.RequestBudget, err = time.ParseDuration(budget)
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_REQUEST_BUDGET: %v", err)
+	}
+	if indexes.RequestBudget <= 0 {
+		return nil, fmt.Errorf("SEARCH_REQUEST_BUDGET must be positive, got %s", indexes.RequestBudget)
+	}
+
+	if aliases := strings.TrimSpace(getenv("SEARCH_ALIASES")); aliases != "" {
+		err := json.Unmarshal([]byte(aliases), &indexes.Aliases)
+		if err != nil {
+			return nil, fmt.Errorf("SEARCH_ALIASES: %v", err)
+		}
+	}
+
+	err = indexes.validate()
+	if err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+func (ix *Indexes) validate() error {
+	cacheDirs := map[string]string{}
+	locations := map[string]string{}
+	for _, name := range ix.Names {
+		cfg := ix.Configs[name]
+		if other, ok := cacheDirs[cfg.CacheDir]; ok {
+			return fmt.Errorf("indexes %v and %v share the cache dir %v", other, name, cfg.CacheDir)
+		}
+		cacheDirs[cfg.CacheDir] = name
+		if other, ok := locations[cfg.location()]; ok {
+			return fmt.Errorf("indexes %v and %v are downloaded from the same %v", other, name, cfg.location())
+		}
+		locations[cfg.location()] = name
+	}
+	for alias, members := range ix.Aliases {
+		if !validName(alias) {
+			return fmt.Errorf("SEARCH_ALIASES: invalid alias name %q", alias)
+		}
+		if ix.Configs[alias] != nil {
+			return fmt.Errorf("SEARCH_ALIASES: alias %v has the name of an index", alias)
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("SEARCH_ALIASES: alias %v has no indexes", alias)
+		}
+		seen := map[string]bool{}
+		for _, m := range members {
+			if ix.Configs[m] == nil {
+				return fmt.Errorf("SEARCH_ALIASES: alias %v refers to unknown index %v", alias, m)
+			}
+			if seen[m] {
+				return fmt.Errorf("SEARCH_ALIASES: alias %v lists index %v twice", alias, m)
+			}
+			seen[m] = true
+		}
+	}
+	return nil
+}
+
+// Default is the configuration of the index served when the request
+// doesn't name one.
+func (ix *Indexes) Default() *Config {
+	return ix.Configs[ix.Names[0]]
+}
+
+// Resolve returns the configurations of the named index or of the members
+// of the named alias. An empty name resolves to the default index.
+func (ix *Indexes) Resolve(name string) ([]*Config, bool) {
+	if name == "" {
+		return []*Config{ix.Default()}, true
+	}
+	if cfg, ok := ix.Configs[name]; ok {
+		return []*Config{cfg}, true
+	}
+	members, ok := ix.Aliases[name]
+	if !ok {
+		return nil, false
+	}
+	res := make([]*Config, 0, len(members))
+	for _, m := range members {
+		res = append(res, ix.Configs[m])
+	}
+	// indexes are always locked in the same order, so that searches over
+	// overlapping aliases can't deadlock each other
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, true
+}
+
+func loadConfig(name string, getenv func(string) string) (*Config, error) {
+	// SEARCH_FOO is looked up as SEARCH_<NAME>_FOO first
+	own := func(v string) string {
+		if !strings.HasPrefix(v, "SEARCH_") {
+			return ""
+		}
+		return strings.TrimSpace(getenv(envPrefix(name) + strings.TrimPrefix(v, "SEARCH_")))
+	}
+	env := func(v, def string) string {
+		if res := own(v); res != "" {
+			return res
+		}
+		if res := strings.TrimSpace(getenv(v)); res != "" {
+			return res
+		}
+		return def
+	}
+
+	timeout, err := time.ParseDuration(env("SEARCH_DOWNLOAD_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_DOWNLOAD_TIMEOUT: %v", err)
+	}
+
+	fetchWait, err := time.ParseDuration(env("SEARCH_FETCH_WAIT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_FETCH_WAIT: %v", err)
+	}
+
+	manifestTTL, err := time.ParseDuration(env("SEARCH_MANIFEST_TTL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_MANIFEST_TTL: %v", err)
+	}
+
+	maxSize, err := strconv.Atoi(env("SEARCH_MAX_SIZE", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_MAX_SIZE: %v", err)
+	}
+
+	datasetName := env("SEARCH_DATASET", name)
+	ds, ok := datasets[datasetName]
+	if !ok {
+		for _, v := range []string{"SEARCH_FIELDS", "SEARCH_FUZZY_FIELDS", "SEARCH_SUGGEST_FIELDS"} {
+			if env(v, "") == "" {
+				return nil, fmt.Errorf("%v must be set, there are no defaults for dataset %v", v, datasetName)
+			}
+		}
+		ds.facets, ds.profile = `{}`, "none"
+	}
+
+	facets := map[string]*api.Facet{}
+	err = json.Unmarshal([]byte(env("SEARCH_FACETS", ds.facets)), &facets)
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_FACETS: %v", err)
+	}
+
+	profile, err := api.ParseProfile(env("SEARCH_PROFILE", ds.profile))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_PROFILE: %v", err)
+	}
+
+	suggestFields := env("SEARCH_SUGGEST_FIELDS", strings.Join(ds.suggestFields, ","))
+	if suggestFields == "none" {
+		suggestFields = ""
+	}
+
+	// the shared cache dir is split between the indexes
+	cacheDir := own("SEARCH_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = path.Join(env("SEARCH_CACHE_DIR", "/tmp"), name)
+	}
+
+	cfg := &Config{
+		Name:            name,
+		Dataset:         datasetName,
+		Source:          env("SEARCH_SOURCE", sourceS3),
+		LocalPath:       env("SEARCH_LOCAL_PATH", ""),
+		URL:             env("SEARCH_URL", ""),
+		Bucket:          env("SEARCH_BUCKET", "sls-search"),
+		Key:             env("SEARCH_KEY", "film/index.tar.zst"),
+		CacheDir:        cacheDir,
+		Endpoint:        env("S3_ENDPOINT", "https://storage.yandexcloud.net"),
+		Region:          env("S3_REGION", "ru-central1"),
+		DownloadTimeout: timeout,
+		FetchWait:       fetchWait,
+		ManifestTTL:     manifestTTL,
+		Fields:          splitList(env("SEARCH_FIELDS", strings.Join(ds.fields, ","))),
+		Profile:         profile,
+		FuzzyFields:     splitList(env("SEARCH_FUZZY_FIELDS", strings.Join(ds.fuzzyFields, ","))),
+		SuggestFields:   splitList(suggestFields),
+		MaxSize:         maxSize,
+		Facets:          facets,
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	switch c.Source {
+	case sourceS3:
+		if c.Bucket == "" {
+			return fmt.Errorf("SEARCH_BUCKET is empty")
+		}
+		if c.Key == "" {
+			return fmt.Errorf("SEARCH_KEY is empty")
+		}
+	case sourceLocal:
+		if c.LocalPath == "" {
+			return fmt.Errorf("SEARCH_LOCAL_PATH is required for the local source")
+		}
+	case sourceHTTP:
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("SEARCH_URL: %v", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("SEARCH_URL must be an http(s) URL, got %q", c.URL)
+		}
+	default:
+		return fmt.Errorf("SEARCH_SOURCE must be one of s3, local or http, got %q", c.Source)
+	}
+	if !path.IsAbs(c.CacheDir) {
+		return fmt.Errorf("SEARCH_CACHE_DIR must be an absolute path, got %q", c.CacheDir)
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("S3_ENDPOINT: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("S3_ENDPOINT must be an http(s) URL, got %q", c.Endpoint)
+	}
+	if c.DownloadTimeout <= 0 {
+		return fmt.Errorf("SEARCH_DOWNLOAD_TIMEOUT must be positive, got %s", c.DownloadTimeout)
+	}
+	if c.FetchWait <= 0 {
+		return fmt.Errorf("SEARCH_FETCH_WAIT must be positive, got %s", c.FetchWait)
+	}
+	if c.ManifestTTL < 0 {
+		return fmt.Errorf("SEARCH_MANIFEST_TTL must not be negative, got %s", c.ManifestTTL)
+	}
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("SEARCH_FIELDS is empty")
+	}
+	if len(c.FuzzyFields) == 0 {
+		return fmt.Errorf("SEARCH_FUZZY_FIELDS is empty")
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("SEARCH_MAX_SIZE must be positive, got %d", c.MaxSize)
+	}
+	for name, f := range c.Facets {
+		if f == nil || f.Field == "" {
+			return fmt.Errorf("SEARCH_FACETS: facet %v has no field", name)
+		}
+	}
+	return nil
+}
+
+// location identifies the published archive of the index.
+func (c *Config) location() string {
+	switch c.Source {
+	case sourceLocal:
+		return c.LocalPath
+	case sourceHTTP:
+		return c.URL
+	}
+	return c.Endpoint + "/" + c.Bucket + "/" + c.Key
+}
+
+// IndexPath is the directory bleve opens the index from.
+func (c *Config) IndexPath() string {
+	return path.Join(c.CacheDir, "index")
+}
+
+func newS3Client(ctx context.Context, c *Config) (*s3.Client, error) {
+	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		if service == s3.ServiceID {
+			return aws.Endpoint{
+				PartitionID:   "yc",
+				URL:           c.Endpoint,
+				SigningRegion: c.Region,
+			}, nil
+		}
+		return aws.Endpoint{}, fmt.Errorf("unknown endpoint requested")
+	})
+
+	// We'll get keys from env variables
+	cfg, err := config.LoadDefaultConfig(
+		ctx,
+		config.WithEndpointResolverWithOptions(customResolver),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+// validName accepts names that are safe in URL paths and environment
+// variable names.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// envPrefix of the variables of a single index, SEARCH_ANEK_ for anek.
+func envPrefix(name string) string {
+	return "SEARCH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
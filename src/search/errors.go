@@ -0,0 +1,71 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"net/http"
+	"time"
+)
+
+// The handlers tell these kinds of failures apart:
+//
+//   - the request is wrong, *api.Error carries the status, 400 mostly;
+//   - the index can't be searched for now, *unavailableError, 503 with
+//     Retry-After, as it's expected to be back once downloaded;
+//   - the request ran out of its budget before anything was found,
+//     errOutOfTime, 504;
+//   - everything else is our fault, 500.
+
+// unavailableError means the index couldn't be downloaded or is still being
+// downloaded. The error of the download is wrapped, see ErrIndexNotFound
+// and its neighbours.
+type unavailableError struct {
+	index string
+	err   error
+	// retryAfter is when the client may try again.
+	retryAfter time.Duration
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("index %v is unavailable: %v", e.index, e.err)
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// errOutOfTime means the request budget ran out, see Indexes.RequestBudget.
+var errOutOfTime = errors.New("request ran out of time")
+
+func outOfTime(ctx context.Context) error {
+	return fmt.Errorf("%w: %v", errOutOfTime, ctx.Err())
+}
+
+// requestErr makes err the fault of the client unless it already tells its
+// own status.
+func requestErr(err error) error {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return &api.Error{Status: http.StatusBadRequest, Message: err.Error()}
+}
+
+// errStatus picks the response status for err and the value of
+// Retry-After, zero if the request shouldn't be retried as is.
+func errStatus(err error) (int, time.Duration) {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status, 0
+	}
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		return http.StatusServiceUnavailable, unavailable.retryAfter
+	}
+	if errors.Is(err, errOutOfTime) {
+		return http.StatusGatewayTimeout, 0
+	}
+	return http.StatusInternalServerError, 0
+}
@@ -0,0 +1,161 @@
+// Package search implements the search function: the index is fetched from
+// its source on a cold start, kept open between invocations and searched
+// by the handlers of this package. They are mounted as Cloud Function
+// entrypoints by the main package and on net/http by cmd/server.
+package search
+
+import (
+	"context"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SearchHandler searches the index named by the request, see Parse of the
+// api package for the request format.
+func SearchHandler(rw http.ResponseWriter, req *http.Request) {
+	logger, _ := zap.NewProduction()
+	ctx, cancel := withBudget(context.WithValue(req.Context(), "durations", map[string]int64{}))
+	defer cancel()
+
+	out, err := runSearch(ctx, logger, req)
+	if err != nil {
+		sendErr(ctx, rw, logger, err)
+		return
+	}
+	send(rw, logger, http.StatusOK, out.body(ctx))
+}
+
+func runSearch(ctx context.Context, logger *zap.Logger, req *http.Request) (*searchOutcome, error) {
+	searchReq, err := api.Parse(req)
+	if err != nil {
+		return nil, requestErr(err)
+	}
+
+	durations := ctx.Value("durations").(map[string]int64)
+	t, err := openTarget(ctx, logger, req)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	start := time.Now()
+	defaults := t.searchDefaults()
+	searchRequest, searchResult, mode, err := searchReq.Search(ctx, t.index, defaults)
+	if err != nil && ctx.Err() != nil {
+		return nil, outOfTime(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if searchResult.Status.Successful == 0 && ctx.Err() != nil {
+		// every index of the alias ran out of time
+		return nil, outOfTime(ctx)
+	}
+	durations["queryIndex"] = time.Now().Sub(start).Microseconds()
+
+	out := &searchOutcome{
+		req:          searchRequest,
+		res:          searchResult,
+		mode:         mode,
+		defaultTypes: t.defaultTypes(),
+		raw:          req.URL.Query().Get("raw") == "1",
+	}
+	if searchReq.Highlight != nil && ctx.Err() == nil {
+		start = time.Now()
+		err = api.ApplyHighlight(t.opened, searchReq.Highlight, searchResult)
+		if err != nil {
+			return nil, err
+		}
+		durations["highlight"] = time.Now().Sub(start).Microseconds()
+	}
+	if searchResult.Total == 0 && strings.TrimSpace(searchReq.Query) != "" && ctx.Err() == nil {
+		start = time.Now()
+		out.didYouMean, err = api.DidYouMean(ctx, t.opened, searchReq.Query, defaults.FuzzyFields)
+		if err != nil {
+			// the answer is still valid without suggestions
+			logger.Error("failed to suggest spelling", zap.Error(err))
+		}
+		durations["didYouMean"] = time.Now().Sub(start).Microseconds()
+	}
+	// what was found in time is still worth responding with, the client is
+	// told it may be incomplete
+	out.timedOut = ctx.Err() != nil
+	return out, nil
+}
+
+// withBudget bounds the request by Indexes.RequestBudget.
+func withBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	indexes, err := getIndexes()
+	if err != nil {
+		// openTarget reports the error
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, indexes.RequestBudget)
+}
+
+// searchDefaults combines the settings of the searched indexes: an alias
+// returns and searches the fields of all of its indexes and offers all of
+// their facets, but pages no larger than any of them allows.
+func (t *target) searchDefaults() api.Defaults {
+	d := api.Defaults{Facets: map[string]*api.Facet{}}
+	seen, seenFuzzy := map[string]bool{}, map[string]bool{}
+	profiled := false
+	for _, idx := range t.served {
+		profiled = profiled || len(idx.cfg.Profile) > 0
+	}
+	for i, idx := range t.served {
+		for _, f := range idx.cfg.Fields {
+			if !seen[f] {
+				seen[f] = true
+				d.Fields = append(d.Fields, f)
+			}
+		}
+		for _, f := range idx.cfg.FuzzyFields {
+			if !seenFuzzy[f] {
+				seenFuzzy[f] = true
+				d.FuzzyFields = append(d.FuzzyFields, f)
+			}
+		}
+		for name, f := range idx.cfg.Facets {
+			if _, ok := d.Facets[name]; !ok {
+				d.Facets[name] = f
+			}
+		}
+		profile := idx.cfg.Profile
+		if len(profile) == 0 && profiled {
+			// the index searches plain text in its default field, which the
+			// profiles of the other indexes would leave out
+			profile = api.Profile{{Field: t.opened[i].Mapping().DefaultSearchField(), Boost: 1}}
+		}
+		d.Profile = d.Profile.Merge(profile)
+		if d.MaxSize == 0 || idx.cfg.MaxSize < d.MaxSize {
+			d.MaxSize = idx.cfg.MaxSize
+		}
+	}
+	d.FieldRange = func(field string) (float64, float64, bool, error) {
+		min, max, found := 0.0, 0.0, false
+		for i, idx := range t.served {
+			lo, hi, ok, err := idx.warm.fieldRange(t.opened[i], field)
+			if err != nil {
+				return 0, 0, false, err
+			}
+			if !ok {
+				continue
+			}
+			if !found || lo < min {
+				min = lo
+			}
+			if !found || hi > max {
+				max = hi
+			}
+			found = true
+		}
+		return min, max, found, nil
+	}
+	return d
+}
@@ -0,0 +1,379 @@
+package search
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"github.com/blevesearch/bleve"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestIndex builds a bleve index of a few films and returns its
+// directory.
+func newTestIndex(t *testing.T) string {
+	t.Helper()
+	dir := path.Join(t.TempDir(), "films")
+	index, err := bleve.New(dir, bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, film := range map[string]map[string]interface{}{
+		"1": {"_type": "film", "filmname": "Terminator", "director": "James Cameron", "crYearOfProduction": 1984},
+		"2": {"_type": "film", "filmname": "Aliens", "director": "James Cameron", "crYearOfProduction": 1986},
+	} {
+		if err := index.Index(id, film); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// tarDir packs dir into a tar archive as the index directory.
+func tarDir(t *testing.T, dir string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join("index", filepath.ToSlash(rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// configure serves the indexes configured by env until the test ends.
+func configure(t *testing.T, env map[string]string) {
+	t.Helper()
+	err := Configure(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeAll(zap.NewNop())
+		servedMu.Lock()
+		served = map[string]*searchIndex{}
+		servedMu.Unlock()
+	})
+}
+
+// waitUpdates waits for the download or update of the index running in the
+// background, so that it doesn't outlive the test.
+func waitUpdates(name string) {
+	servedMu.Lock()
+	idx := served[name]
+	servedMu.Unlock()
+	if idx != nil {
+		idx.updates.mu.Lock()
+		idx.updates.mu.Unlock()
+	}
+}
+
+// recorder counts the statuses written by a handler.
+type recorder struct {
+	*httptest.ResponseRecorder
+	statuses int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.statuses++
+	r.ResponseRecorder.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.statuses == 0 {
+		r.statuses++
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+// do serves a single request and checks that exactly one status and one
+// JSON body were written.
+func do(t *testing.T, h http.HandlerFunc, method, target, body string) *recorder {
+	t.Helper()
+	rw := &recorder{ResponseRecorder: httptest.NewRecorder()}
+	h(rw, httptest.NewRequest(method, target, strings.NewReader(body)))
+	if rw.statuses != 1 {
+		t.Errorf("%v %v: %d statuses written", method, target, rw.statuses)
+	}
+	dec := json.NewDecoder(bytes.NewReader(rw.Body.Bytes()))
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Errorf("%v %v: body is not JSON: %v", method, target, err)
+	}
+	if err := dec.Decode(&v); err != io.EOF {
+		t.Errorf("%v %v: more than one body written", method, target)
+	}
+	return rw
+}
+
+func TestHandlerStatuses(t *testing.T) {
+	released := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, ".manifest.json") {
+			_, _ = rw.Write([]byte(`{"version": "1"}`))
+			return
+		}
+		select {
+		case <-released:
+		case <-req.Context().Done():
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(slow.Close)
+
+	broken := t.TempDir()
+	if err := os.WriteFile(path.Join(broken, "readme"), []byte("not an index"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configure(t, map[string]string{
+		"SEARCH_INDEXES":             "films,missing,slow,broken",
+		"SEARCH_SOURCE":              "local",
+		"SEARCH_LOCAL_PATH":          newTestIndex(t),
+		"SEARCH_CACHE_DIR":           t.TempDir(),
+		"SEARCH_FETCH_WAIT":          "2s",
+		"SEARCH_MISSING_DATASET":     "films",
+		"SEARCH_MISSING_LOCAL_PATH":  path.Join(t.TempDir(), "index.tar"),
+		"SEARCH_SLOW_DATASET":        "films",
+		"SEARCH_SLOW_SOURCE":         "http",
+		"SEARCH_SLOW_URL":            slow.URL + "/index.tar",
+		"SEARCH_SLOW_FETCH_WAIT":     "100ms",
+		"SEARCH_BROKEN_DATASET":      "films",
+		"SEARCH_BROKEN_LOCAL_PATH":   broken,
+		"SEARCH_BROKEN_MANIFEST_TTL": "0",
+	})
+	t.Cleanup(func() {
+		close(released)
+		waitUpdates("slow")
+	})
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		target     string
+		body       string
+		status     int
+		retryAfter string
+		// contains is a part of the body
+		contains string
+	}{
+		{
+			name:     "search",
+			handler:  SearchHandler,
+			method:   http.MethodGet,
+			target:   "/search/films?term=terminator",
+			status:   http.StatusOK,
+			contains: `"filmname":"Terminator"`,
+		},
+		{
+			name:     "bad json",
+			handler:  SearchHandler,
+			method:   http.MethodPost,
+			target:   "/search/films",
+			body:     `{"query":`,
+			status:   http.StatusBadRequest,
+			contains: `"error":`,
+		},
+		{
+			name:     "bad syntax",
+			handler:  SearchHandler,
+			method:   http.MethodGet,
+			target:   "/search/films?term=filmname:",
+			status:   http.StatusBadRequest,
+			contains: `"syntax":{"position":10`,
+		},
+		{
+			name:    "method not allowed",
+			handler: SearchHandler,
+			method:  http.MethodDelete,
+			target:  "/search/films",
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "unknown index",
+			handler:  SearchHandler,
+			method:   http.MethodGet,
+			target:   "/search/nope?term=terminator",
+			status:   http.StatusNotFound,
+			contains: "unknown index nope",
+		},
+		{
+			name:       "missing archive",
+			handler:    SearchHandler,
+			method:     http.MethodGet,
+			target:     "/search/missing?term=terminator",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "2",
+			contains:   "index archive not found",
+		},
+		{
+			name:       "slow archive",
+			handler:    SearchHandler,
+			method:     http.MethodGet,
+			target:     "/search/slow?term=terminator",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "1",
+			contains:   "still being downloaded",
+		},
+		{
+			name:       "suggest of a slow archive",
+			handler:    SuggestHandler,
+			method:     http.MethodGet,
+			target:     "/suggest/slow?prefix=ter",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: "1",
+		},
+		{
+			name:    "broken index",
+			handler: SearchHandler,
+			method:  http.MethodGet,
+			target:  "/search/broken?term=terminator",
+			status:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := do(t, tt.handler, tt.method, tt.target, tt.body)
+			if rw.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rw.Code, tt.status, rw.Body)
+			}
+			if got := rw.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tt.retryAfter)
+			}
+			if !strings.Contains(rw.Body.String(), tt.contains) {
+				t.Errorf("body %s doesn't contain %s", rw.Body, tt.contains)
+			}
+		})
+	}
+}
+
+func TestHandlerOutOfTime(t *testing.T) {
+	env := map[string]string{
+		"SEARCH_SOURCE":       "local",
+		"SEARCH_LOCAL_PATH":   newTestIndex(t),
+		"SEARCH_CACHE_DIR":    t.TempDir(),
+		"SEARCH_MANIFEST_TTL": "0",
+	}
+	configure(t, env)
+	rw := do(t, SearchHandler, http.MethodGet, "/?term=terminator", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rw.Code, http.StatusOK, rw.Body)
+	}
+
+	// the index is warm, only the search itself can run out of time
+	env["SEARCH_REQUEST_BUDGET"] = "1ns"
+	configure(t, env)
+	for _, target := range []string{"/?term=terminator", "/suggest?prefix=ter"} {
+		h := SearchHandler
+		if strings.HasPrefix(target, "/suggest") {
+			h = SuggestHandler
+		}
+		rw := do(t, h, http.MethodGet, target, "")
+		if rw.Code != http.StatusGatewayTimeout {
+			t.Errorf("%v: status %d, want %d: %s", target, rw.Code, http.StatusGatewayTimeout, rw.Body)
+		}
+		if got := rw.Header().Get("Retry-After"); got != "" {
+			t.Errorf("%v: Retry-After %q, want none", target, got)
+		}
+	}
+}
+
+func TestUpdateInBackground(t *testing.T) {
+	archive := tarDir(t, newTestIndex(t))
+	var mu sync.Mutex
+	version := "1"
+	released := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		v := version
+		mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, ".manifest.json") {
+			_, _ = rw.Write([]byte(`{"version": "` + v + `"}`))
+			return
+		}
+		if v != "1" {
+			<-released
+		}
+		_, _ = rw.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+
+	configure(t, map[string]string{
+		"SEARCH_SOURCE":         "http",
+		"SEARCH_URL":            srv.URL + "/index.tar",
+		"SEARCH_CACHE_DIR":      t.TempDir(),
+		"SEARCH_MANIFEST_TTL":   "1ms",
+		"SEARCH_REQUEST_BUDGET": "2s",
+	})
+	rw := do(t, SearchHandler, http.MethodGet, "/?term=terminator", "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rw.Code, http.StatusOK, rw.Body)
+	}
+
+	mu.Lock()
+	version = "2"
+	mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+
+	// the new version is being downloaded, the old one is still served
+	start := time.Now()
+	rw = do(t, SearchHandler, http.MethodGet, "/?term=terminator", "")
+	if rw.Code != http.StatusOK {
+		t.Errorf("status %d, want %d: %s", rw.Code, http.StatusOK, rw.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request waited %v for the download", elapsed)
+	}
+
+	close(released)
+	waitUpdates("films")
+	m, err := manifest.ReadFile(path.Join(served["films"].cfg.IndexPath(), manifest.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "2" {
+		t.Errorf("served version %v, want 2", m.Version)
+	}
+}
@@ -0,0 +1,210 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/eikenb/pipeat"
+	"github.com/nikolaymatrosov/go-sls-search/extract"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrIndexNotFound means the source has no archive of the index.
+	ErrIndexNotFound = errors.New("index archive not found")
+	// ErrDownloadTimeout means the archive wasn't downloaded within
+	// Config.DownloadTimeout.
+	ErrDownloadTimeout = errors.New("index download timed out")
+	// ErrCorruptArchive means the archive can't be extracted or doesn't
+	// match its checksum.
+	ErrCorruptArchive = errors.New("corrupt index archive")
+)
+
+func fetchIndex(ctx context.Context, idx *searchIndex, logger *zap.Logger) error {
+	cfg := idx.cfg
+	durations := ctx.Value("durations").(map[string]int64)
+	start := time.Now()
+	defer func() {
+		durations["fetchIndex"] = time.Now().Sub(start).Microseconds()
+	}()
+	idx.updates.mu.Lock()
+	defer idx.updates.mu.Unlock()
+
+	lockCtx, cancel := context.WithTimeout(ctx, cfg.DownloadTimeout)
+	defer cancel()
+	unlock, err := lockCacheDir(lockCtx, cfg)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// another process is downloading and takes too long
+		err = fmt.Errorf("%w: %v", ErrDownloadTimeout, err)
+	}
+	if err != nil {
+		logger.Error("failed to lock cache dir", zap.Error(err))
+		return err
+	}
+	defer unlock()
+	// another process could have fetched the index while we were waiting
+	if checkCache(ctx, cfg) == nil {
+		return nil
+	}
+
+	source, err := newIndexSource(ctx, cfg)
+	if err != nil {
+		logger.Error("failed to init index source", zap.Error(err))
+		return err
+	}
+	m, err := fetchManifest(ctx, cfg, source)
+	if err != nil {
+		logger.Error("failed to fetch index manifest", zap.Error(err))
+		return err
+	}
+	removeStaging(cfg, logger)
+
+	err = installIndex(ctx, cfg, source, m, logger)
+	if err != nil {
+		logger.Error("Failed to download and unzip index", zap.Error(err))
+		return err
+	}
+	idx.updates.checkedAt = time.Now()
+	return nil
+}
+
+// fetchManifest gets the manifest within cfg.DownloadTimeout. The updates
+// of the index are locked meanwhile, a stalled request must not keep them
+// locked for good.
+func fetchManifest(ctx context.Context, cfg *Config, source IndexSource) (*manifest.Manifest, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.DownloadTimeout)
+	defer cancel()
+	m, err := source.Manifest(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: manifest of %v after %v", ErrDownloadTimeout, source.Name(), cfg.DownloadTimeout)
+	}
+	return m, err
+}
+
+// downloadAndUnzip extracts the archive into root while it is downloaded.
+// If checksum is not empty the SHA-256 of the downloaded bytes must match
+// it, otherwise the content of root can't be trusted. Whichever of the
+// download and the extraction fails first stops the other one.
+func downloadAndUnzip(ctx context.Context, cfg *Config, source IndexSource, checksum string, root string, l *zap.Logger) error {
+	durations := ctx.Value("durations").(map[string]int64)
+	start := time.Now()
+	defer func() {
+		durations["downloadAndUnzip"] = time.Now().Sub(start).Microseconds()
+	}()
+	pipeReaderAt, pipeWriterAt, err := pipeat.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create download buffer: %v", err)
+	}
+
+	downloadCtx, cancel := context.WithTimeout(ctx, cfg.DownloadTimeout)
+	defer cancel()
+	timedOut := func(err error) error {
+		if err != nil && errors.Is(downloadCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %v after %v", ErrDownloadTimeout, source.Name(), cfg.DownloadTimeout)
+		}
+		return err
+	}
+
+	// closed when the extraction stops reading, the download fails after
+	// that and it's up to the extraction to tell why
+	extracted := make(chan struct{})
+	g, gCtx := errgroup.WithContext(downloadCtx)
+	g.Go(func() error {
+		err := timedOut(source.Download(gCtx, pipeWriterAt))
+		select {
+		case <-extracted:
+			err = nil
+		default:
+		}
+		if err != nil {
+			l.Error("failed to download index", zap.String("source", source.Name()), zap.Error(err))
+		}
+		// the extraction gets err once it reads everything written so far,
+		// closing waits for it to finish
+		_ = pipeWriterAt.CloseWithError(err)
+		return err
+	})
+	g.Go(func() error {
+		stream := &downloadReader{r: pipeReaderAt}
+		err := timedOut(extractVerified(gCtx, source.Name(), stream, checksum, root))
+		if stream.err != nil {
+			// the download failed, it's not the archive to blame
+			err = stream.err
+		} else if errors.Is(err, ErrCorruptArchive) {
+			l.Error("failed to extract index", zap.String("source", source.Name()), zap.Error(err))
+		}
+		close(extracted)
+		// a download ahead of the extraction is blocked until it is closed
+		_ = pipeReaderAt.CloseWithError(err)
+		return err
+	})
+	return g.Wait()
+}
+
+// extractVerified extracts the archive read from r into root and checks its
+// checksum if one is given.
+func extractVerified(ctx context.Context, name string, r io.Reader, checksum string, root string) error {
+	hash := sha256.New()
+	stream := io.TeeReader(r, hash)
+	err := extract.Extract(ctx, name, stream, root, extract.DefaultLimits)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+	}
+	// the archive may have trailing bytes the extractor didn't need, the
+	// download can't finish until they are read
+	_, err = io.Copy(io.Discard, stream)
+	if err != nil {
+		return err
+	}
+	if checksum == "" {
+		return nil
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("%w: checksum mismatch for %v: expected %v, got %v", ErrCorruptArchive, name, checksum, actual)
+	}
+	return nil
+}
+
+// downloadReader remembers the error the download failed with, so that it
+// is not mistaken for a broken archive.
+type downloadReader struct {
+	r   io.Reader
+	err error
+}
+
+func (d *downloadReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+func checkCache(ctx context.Context, cfg *Config) error {
+	durations := ctx.Value("durations").(map[string]int64)
+	start := time.Now()
+	defer func() {
+		durations["checkCache"] = time.Now().Sub(start).Microseconds()
+	}()
+	info, err := os.Stat(cfg.IndexPath())
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("index is not dir")
+	}
+	// the manifest is written only after the archive was verified and fully
+	// extracted, an index without it is a leftover of a failed download
+	_, err = os.Stat(path.Join(cfg.IndexPath(), manifest.FileName))
+	return err
+}
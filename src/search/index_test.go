@@ -0,0 +1,218 @@
+package search
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), "durations", map[string]int64{})
+}
+
+func testConfig(t *testing.T) *Config {
+	return &Config{
+		Name:            "films",
+		Source:          sourceLocal,
+		CacheDir:        t.TempDir(),
+		DownloadTimeout: 5 * time.Second,
+		FetchWait:       5 * time.Second,
+	}
+}
+
+// testArchive is a tar archive of an index directory with a single file.
+func testArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	body := strings.Repeat("bleve ", 1000)
+	for _, hdr := range []*tar.Header{
+		{Name: "index/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "index/store", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeArchive(t *testing.T, data []byte) *localSource {
+	t.Helper()
+	p := path.Join(t.TempDir(), "index.tar")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &localSource{path: p}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestInstallIndex(t *testing.T) {
+	data := testArchive(t)
+	cfg := testConfig(t)
+	m := &manifest.Manifest{Version: "1", SHA256: checksum(data)}
+
+	err := installIndex(testContext(), cfg, writeArchive(t, data), m, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCache(testContext(), cfg); err != nil {
+		t.Fatalf("index is not in the cache: %v", err)
+	}
+	installed, err := manifest.ReadFile(path.Join(cfg.IndexPath(), manifest.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed.Version != m.Version {
+		t.Errorf("installed version %v, want %v", installed.Version, m.Version)
+	}
+	assertNoStaging(t, cfg)
+}
+
+func TestInstallIndexFails(t *testing.T) {
+	data := testArchive(t)
+	errReset := errors.New("connection reset")
+	tests := []struct {
+		name     string
+		source   func(t *testing.T) IndexSource
+		checksum string
+		timeout  time.Duration
+		err      error
+	}{
+		{
+			name:     "checksum mismatch",
+			source:   func(t *testing.T) IndexSource { return writeArchive(t, data) },
+			checksum: checksum([]byte("another archive")),
+			err:      ErrCorruptArchive,
+		},
+		{
+			name: "truncated archive",
+			source: func(t *testing.T) IndexSource {
+				return writeArchive(t, data[:len(data)/2])
+			},
+			err: ErrCorruptArchive,
+		},
+		{
+			name: "broken download",
+			source: func(t *testing.T) IndexSource {
+				return &brokenSource{data: data[:len(data)/2], err: errReset}
+			},
+			checksum: checksum(data),
+			err:      errReset,
+		},
+		{
+			name: "stalled download",
+			source: func(t *testing.T) IndexSource {
+				return &brokenSource{data: data[:len(data)/2]}
+			},
+			timeout: 50 * time.Millisecond,
+			err:     ErrDownloadTimeout,
+		},
+		{
+			name: "missing archive",
+			source: func(t *testing.T) IndexSource {
+				return &localSource{path: path.Join(t.TempDir(), "index.tar")}
+			},
+			err: ErrIndexNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			if tt.timeout > 0 {
+				cfg.DownloadTimeout = tt.timeout
+			}
+			m := &manifest.Manifest{Version: "1", SHA256: tt.checksum}
+
+			err := installIndex(testContext(), cfg, tt.source(t), m, zap.NewNop())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if tt.err != ErrCorruptArchive && errors.Is(err, ErrCorruptArchive) {
+				t.Errorf("the archive is blamed for %v", err)
+			}
+			if _, err := os.Lstat(cfg.IndexPath()); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("index was activated: %v", err)
+			}
+			assertNoStaging(t, cfg)
+		})
+	}
+}
+
+func TestFetchManifestTimesOut(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	cfg := testConfig(t)
+	cfg.DownloadTimeout = 50 * time.Millisecond
+	source := &httpSource{url: srv.URL + "/index.tar", client: http.DefaultClient}
+
+	_, err := fetchManifest(testContext(), cfg, source)
+	if !errors.Is(err, ErrDownloadTimeout) {
+		t.Fatalf("got %v, want %v", err, ErrDownloadTimeout)
+	}
+}
+
+// brokenSource writes a part of the archive and fails with err, or hangs
+// until the download times out if err is nil.
+type brokenSource struct {
+	data []byte
+	err  error
+}
+
+func (s *brokenSource) Name() string {
+	return "index.tar"
+}
+
+func (s *brokenSource) Download(ctx context.Context, w io.WriterAt) error {
+	_, err := w.WriteAt(s.data, 0)
+	if err != nil {
+		return err
+	}
+	if s.err != nil {
+		return s.err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *brokenSource) Manifest(_ context.Context) (*manifest.Manifest, error) {
+	return &manifest.Manifest{Version: "1"}, nil
+}
+
+func assertNoStaging(t *testing.T, cfg *Config) {
+	t.Helper()
+	entries, err := os.ReadDir(cfg.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), stagingPrefix) {
+			t.Errorf("staging dir %v is left behind", e.Name())
+		}
+	}
+}
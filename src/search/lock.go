@@ -0,0 +1,65 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// lockFileName is locked by whoever installs a version of the index into
+// the cache dir. The lock is what keeps processes sharing the cache dir,
+// such as a local server next to a function emulator, from downloading
+// into it at the same time; requests within a process are coordinated by
+// searchIndex.fetch and updateState.
+const lockFileName = ".lock"
+
+const lockRetryInterval = 50 * time.Millisecond
+
+var errLocked = errors.New("locked by another process")
+
+// lockCacheDir waits for the lock of the cache dir until ctx is done. The
+// returned func releases it.
+func lockCacheDir(ctx context.Context, cfg *Config) (func(), error) {
+	for {
+		unlock, err := tryLockCacheDir(cfg)
+		if err == nil {
+			return unlock, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cache dir %v is %v: %w", cfg.CacheDir, err, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// tryLockCacheDir takes the lock of the cache dir if it is free and fails
+// with errLocked otherwise.
+func tryLockCacheDir(cfg *Config) (func(), error) {
+	err := os.MkdirAll(cfg.CacheDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(cfg.CacheDir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// the pid tells who holds the lock when a download looks stuck
+	_ = f.Truncate(0)
+	_, _ = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
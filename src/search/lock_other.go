@@ -0,0 +1,18 @@
+//go:build !unix
+
+package search
+
+import (
+	"os"
+)
+
+// Processes aren't coordinated where flock is not available, which is
+// fine for development.
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
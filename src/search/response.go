@@ -0,0 +1,98 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/blevesearch/bleve"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// sendErr responds with the status err calls for, see errStatus.
+func sendErr(_ context.Context, rw http.ResponseWriter, l *zap.Logger, err error) {
+	status, retryAfter := errStatus(err)
+	if status >= http.StatusInternalServerError {
+		l.Error("request failed", zap.Int("status", status), zap.Error(err))
+	}
+	if retryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	body := errorBody{Error: err.Error()}
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		body.Syntax = apiErr.Syntax
+	}
+	send(rw, l, status, body)
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+	// Syntax points at the problem with a malformed query.
+	Syntax *api.SyntaxError `json:"syntax,omitempty"`
+}
+
+// send is where every response of the handlers is written.
+func send(rw http.ResponseWriter, l *zap.Logger, status int, body interface{}) {
+	jsonResp, err := json.Marshal(body)
+	if err != nil {
+		l.Error("Error happened in JSON marshal.", zap.Error(err))
+		status = http.StatusInternalServerError
+		jsonResp = []byte(`{"error":"failed to encode response"}`)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, err = rw.Write(jsonResp)
+	if err != nil {
+		l.Error("Failed to write response.", zap.Error(err))
+	}
+}
+
+type SearchResultWithTimings struct {
+	*bleve.SearchResult
+	// Total duplicates total_hits of the embedded result on the top level.
+	Total uint64 `json:"total"`
+	// NextSearchAfter is passed as search_after to get the next page.
+	NextSearchAfter string `json:"next_search_after,omitempty"`
+	// Mode the hits were found in, exact or fuzzy.
+	Mode       string           `json:"mode"`
+	DidYouMean []string         `json:"did_you_mean,omitempty"`
+	TimedOut   bool             `json:"timed_out,omitempty"`
+	Durations  map[string]int64 `json:"durations"`
+}
+
+// searchOutcome is everything SearchHandler responds with.
+type searchOutcome struct {
+	req  *bleve.SearchRequest
+	res  *bleve.SearchResult
+	mode string
+	// defaultTypes of documents by index name, see api.NewSearchResponse.
+	defaultTypes map[string]string
+	didYouMean   []string
+	timedOut     bool
+	raw          bool
+}
+
+// body of the search response. With raw set it is the bleve result as is,
+// which is handy for debugging but has no stable schema.
+func (out *searchOutcome) body(ctx context.Context) interface{} {
+	durations := ctx.Value("durations").(map[string]int64)
+	if out.raw {
+		return SearchResultWithTimings{
+			out.res,
+			out.res.Total,
+			api.NextCursor(out.req, out.res),
+			out.mode,
+			out.didYouMean,
+			out.timedOut,
+			durations,
+		}
+	}
+	resp := api.NewSearchResponse(out.req, out.res, out.mode, out.defaultTypes, durations)
+	resp.DidYouMean = out.didYouMean
+	resp.TimedOut = out.timedOut
+	return resp
+}
@@ -0,0 +1,262 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// searchIndex is an index served by the function together with the state
+// kept for it between invocations.
+type searchIndex struct {
+	cfg     *Config
+	warm    *warmIndex
+	updates *updateState
+	// fetching shares a cold start download between concurrent requests.
+	fetching singleflight.Group
+}
+
+var (
+	servedMu sync.Mutex
+	served   = map[string]*searchIndex{}
+	shutdown sync.Once
+)
+
+// serve returns the state of the configured index, it is created on the
+// first request to the index.
+func serve(cfg *Config, l *zap.Logger) *searchIndex {
+	shutdown.Do(closeOnShutdown(l))
+
+	servedMu.Lock()
+	defer servedMu.Unlock()
+	idx, ok := served[cfg.Name]
+	if !ok {
+		idx = &searchIndex{
+			cfg:     cfg,
+			warm:    &warmIndex{name: cfg.Name},
+			updates: &updateState{},
+		}
+		served[cfg.Name] = idx
+	}
+	return idx
+}
+
+// target is what a request searches: a single index or all indexes of an
+// alias. The indexes stay open until release is called.
+type target struct {
+	served []*searchIndex
+	opened []bleve.Index
+	// index searches all opened indexes at once.
+	index    bleve.Index
+	releases []func()
+}
+
+// openTarget prepares and acquires the indexes named by the request, see
+// indexName.
+func openTarget(ctx context.Context, l *zap.Logger, req *http.Request) (*target, error) {
+	indexes, err := getIndexes()
+	if err != nil {
+		l.Error("invalid config", zap.Error(err))
+		return nil, err
+	}
+	name := indexName(req)
+	configs, ok := indexes.Resolve(name)
+	if !ok {
+		return nil, &api.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("unknown index %v", name)}
+	}
+
+	t := &target{}
+	for _, cfg := range configs {
+		idx := serve(cfg, l)
+		err = idx.prepare(ctx, l)
+		if err != nil {
+			return nil, &unavailableError{index: cfg.Name, err: err, retryAfter: cfg.FetchWait}
+		}
+		t.served = append(t.served, idx)
+	}
+	for _, idx := range t.served {
+		index, release, err := idx.warm.acquire(ctx, l, idx.cfg.IndexPath())
+		if err != nil {
+			l.Error("error open index", zap.String("index", idx.cfg.Name), zap.Error(err))
+			t.release()
+			return nil, err
+		}
+		t.releases = append(t.releases, release)
+		t.opened = append(t.opened, index)
+	}
+	t.index = t.opened[0]
+	if len(t.opened) > 1 {
+		t.index = bleve.NewIndexAlias(t.opened...)
+	}
+	return t, nil
+}
+
+func (t *target) release() {
+	for _, release := range t.releases {
+		release()
+	}
+	t.releases = nil
+}
+
+// defaultTypes maps the names of the indexes to the type of documents
+// indexed without the type field.
+func (t *target) defaultTypes() map[string]string {
+	types := map[string]string{}
+	for _, index := range t.opened {
+		if m, ok := index.Mapping().(*mapping.IndexMappingImpl); ok {
+			types[index.Name()] = m.DefaultType
+		}
+	}
+	return types
+}
+
+// indexName is taken from the index parameter or from the path, such as
+// /search/<name> or /suggest/<name>. Empty name stands for the default
+// index.
+func indexName(req *http.Request) string {
+	if name := req.URL.Query().Get("index"); name != "" {
+		return name
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "search" || parts[i] == "suggest" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// prepare makes sure the index is on disk: it is downloaded on a cold start
+// and checked for a newer version on a warm one.
+func (idx *searchIndex) prepare(ctx context.Context, l *zap.Logger) error {
+	err := checkCache(ctx, idx.cfg)
+	if err == nil {
+		l.Info("cache hit", zap.String("index", idx.cfg.Name))
+		idx.update(ctx, l)
+		return nil
+	}
+	l.Error("check result", zap.String("index", idx.cfg.Name), zap.Error(err))
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return idx.fetch(ctx, l)
+}
+
+// update runs checkForUpdate. The request waits for the manifest to be
+// compared as long as it has time, but never for a new version to be
+// downloaded: that happens in the background and the request goes on with
+// the version it has.
+func (idx *searchIndex) update(ctx context.Context, l *zap.Logger) {
+	updateCtx := detach()
+	checked := make(chan struct{})
+	go func() {
+		install, err := checkForUpdate(updateCtx, idx, l)
+		close(checked)
+		if err == nil && install != nil {
+			// the durations of updateCtx belong to the request by now
+			err = install(detach())
+		}
+		if err != nil {
+			// keep serving the version we have
+			l.Error("failed to update index", zap.String("index", idx.cfg.Name), zap.Error(err))
+		}
+	}()
+	select {
+	case <-checked:
+		addDurations(ctx, updateCtx)
+	case <-ctx.Done():
+	}
+}
+
+// errFetchInProgress is returned to requests that gave up waiting for the
+// index to download.
+var errFetchInProgress = errors.New("index is still being downloaded")
+
+// fetch downloads the index on a cold start. Requests coming while it is
+// downloaded share the download instead of starting their own, wait for it
+// at most cfg.FetchWait or until they run out of time and all get its
+// error if it fails. The download goes on when they give up, so that the
+// next request finds the index.
+func (idx *searchIndex) fetch(ctx context.Context, l *zap.Logger) error {
+	ch := idx.fetching.DoChan(idx.cfg.Name, func() (interface{}, error) {
+		// the request that started the download may be long gone by the
+		// time it finishes
+		fetchCtx := detach()
+		err := fetchIndex(fetchCtx, idx, l)
+		return fetchCtx, err
+	})
+
+	timer := time.NewTimer(idx.cfg.FetchWait)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		addDurations(ctx, res.Val.(context.Context))
+		return res.Err
+	case <-timer.C:
+		return fmt.Errorf("%w: gave up waiting after %v", errFetchInProgress, idx.cfg.FetchWait)
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", errFetchInProgress, ctx.Err())
+	}
+}
+
+// detach starts a context for work that outlives the request, such as a
+// download shared with other requests. The context has no deadline, every
+// step of the work sets its own: see lockCacheDir, fetchManifest and
+// downloadAndUnzip.
+func detach() context.Context {
+	return context.WithValue(context.Background(), "durations", map[string]int64{})
+}
+
+// addDurations copies the durations measured in a detached context into
+// ctx. The detached work must be done by then.
+func addDurations(ctx context.Context, from context.Context) {
+	durations := ctx.Value("durations").(map[string]int64)
+	for k, v := range from.Value("durations").(map[string]int64) {
+		durations[k] = v
+	}
+}
+
+func closeOnShutdown(l *zap.Logger) func() {
+	return func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		go func() {
+			s := <-sig
+			closeAll(l)
+			// let the runtime terminate the process as it would without us
+			signal.Stop(sig)
+			p, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				_ = p.Signal(s)
+			}
+		}()
+	}
+}
+
+// Close closes the open indexes once the searches running against them are
+// done. It is meant for servers shutting down gracefully, a function
+// instance closes them on SIGTERM by itself.
+func Close() {
+	logger, _ := zap.NewProduction()
+	closeAll(logger)
+}
+
+func closeAll(l *zap.Logger) {
+	servedMu.Lock()
+	defer servedMu.Unlock()
+	for _, idx := range served {
+		idx.warm.close(l)
+	}
+}
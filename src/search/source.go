@@ -0,0 +1,278 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mholt/archiver/v4"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// IndexSource is a place the index archive is fetched from.
+type IndexSource interface {
+	// Name is the archive file name. It is used to detect the archive format.
+	Name() string
+	// Download writes the whole archive to w. Implementations are free to
+	// write parts of the archive out of order.
+	Download(ctx context.Context, w io.WriterAt) error
+	// Manifest describes the archive Download would fetch right now. When no
+	// manifest was published alongside the archive, the version is derived
+	// from the archive metadata (ETag, modification time).
+	Manifest(ctx context.Context) (*manifest.Manifest, error)
+}
+
+const (
+	sourceS3    = "s3"
+	sourceLocal = "local"
+	sourceHTTP  = "http"
+)
+
+func newIndexSource(ctx context.Context, cfg *Config) (IndexSource, error) {
+	switch cfg.Source {
+	case sourceS3:
+		client, err := newS3Client(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init s3 config: %w", err)
+		}
+		return &s3Source{client: client, bucket: cfg.Bucket, key: cfg.Key}, nil
+	case sourceLocal:
+		return &localSource{path: cfg.LocalPath}, nil
+	case sourceHTTP:
+		return &httpSource{url: cfg.URL, client: http.DefaultClient}, nil
+	}
+	return nil, fmt.Errorf("unknown index source %q", cfg.Source)
+}
+
+// s3Source downloads the archive from S3 compatible object storage in
+// several parallel parts.
+type s3Source struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+func (s *s3Source) Name() string {
+	return s.key
+}
+
+func (s *s3Source) Download(ctx context.Context, w io.WriterAt) error {
+	downloader := manager.NewDownloader(s.client)
+	_, err := downloader.Download(ctx, w, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return fmt.Errorf("%w: s3://%v/%v", ErrIndexNotFound, s.bucket, s.key)
+	}
+	return err
+}
+
+func (s *s3Source) Manifest(ctx context.Context) (*manifest.Manifest, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(manifest.KeyFor(s.key)),
+	})
+	if err == nil {
+		defer out.Body.Close()
+		return manifest.Read(out.Body)
+	}
+	var noSuchKey *types.NoSuchKey
+	if !errors.As(err, &noSuchKey) {
+		return nil, err
+	}
+
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("%w: s3://%v/%v", ErrIndexNotFound, s.bucket, s.key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest.Manifest{
+		Version: strings.Trim(aws.ToString(head.ETag), `"`),
+		SHA256:  head.Metadata["sha256"],
+	}
+	if head.LastModified != nil {
+		m.BuildTime = *head.LastModified
+	}
+	return m, nil
+}
+
+// localSource reads the archive from the local filesystem. If path is a
+// directory it is expected to be a ready index, which is packed into tar on
+// the fly, so the rest of the pipeline doesn't have to care.
+type localSource struct {
+	path string
+}
+
+func (s *localSource) Name() string {
+	info, err := os.Stat(s.path)
+	if err == nil && info.IsDir() {
+		return "index.tar"
+	}
+	return path.Base(s.path)
+}
+
+func (s *localSource) Download(ctx context.Context, w io.WriterAt) error {
+	info, err := s.stat()
+	if err != nil {
+		return err
+	}
+	out := &offsetWriter{w: w}
+	if info.IsDir() {
+		files, err := archiver.FilesFromDisk(nil, map[string]string{s.path: "index"})
+		if err != nil {
+			return err
+		}
+		return archiver.Tar{}.Archive(ctx, out, files)
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return err
+}
+
+func (s *localSource) Manifest(_ context.Context) (*manifest.Manifest, error) {
+	m, err := manifest.ReadFile(manifest.KeyFor(s.path))
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return m, err
+	}
+	if info, err := os.Stat(s.path); err == nil && info.IsDir() {
+		m, err := manifest.ReadFile(path.Join(s.path, manifest.FileName))
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return m, err
+		}
+	}
+
+	info, err := s.stat()
+	if err != nil {
+		return nil, err
+	}
+	return &manifest.Manifest{
+		Version:   fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()),
+		BuildTime: info.ModTime(),
+	}, nil
+}
+
+func (s *localSource) stat() (os.FileInfo, error) {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrIndexNotFound, s.path)
+	}
+	return info, err
+}
+
+// httpSource downloads the archive with a plain GET request.
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) Name() string {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return s.url
+	}
+	return path.Base(u.Path)
+}
+
+func (s *httpSource) Download(ctx context.Context, w io.WriterAt) error {
+	resp, err := s.do(ctx, http.MethodGet, s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", ErrIndexNotFound, s.url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %v: %v", s.url, resp.Status)
+	}
+	_, err = io.Copy(&offsetWriter{w: w}, resp.Body)
+	return err
+}
+
+func (s *httpSource) Manifest(ctx context.Context) (*manifest.Manifest, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = manifest.KeyFor(u.Path)
+	resp, err := s.do(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return manifest.Read(resp.Body)
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("failed to fetch manifest %v: %v", u, resp.Status)
+	}
+
+	head, err := s.do(ctx, http.MethodHead, s.url)
+	if err != nil {
+		return nil, err
+	}
+	head.Body.Close()
+	if head.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %v", ErrIndexNotFound, s.url)
+	}
+	if head.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to stat %v: %v", s.url, head.Status)
+	}
+	m := &manifest.Manifest{
+		Version: strings.Trim(head.Header.Get("ETag"), `"`),
+	}
+	if lastModified, err := http.ParseTime(head.Header.Get("Last-Modified")); err == nil {
+		m.BuildTime = lastModified
+		if m.Version == "" {
+			m.Version = lastModified.UTC().Format(time.RFC3339)
+		}
+	}
+	if m.Version == "" {
+		return nil, fmt.Errorf("%v has neither manifest, nor ETag, nor Last-Modified", s.url)
+	}
+	return m, nil
+}
+
+func (s *httpSource) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+// offsetWriter turns io.WriterAt into a sequential io.Writer.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,93 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpeedHandler measures how fast a file next to the index archive is
+// downloaded from the index source.
+func SpeedHandler(rw http.ResponseWriter, req *http.Request) {
+	logger, _ := zap.NewProduction()
+	start := time.Now()
+
+	indexes, err := getIndexes()
+	if err != nil {
+		sendErr(req.Context(), rw, logger, err)
+		return
+	}
+	cfg := indexes.Default()
+
+	qFile := req.URL.Query().Get("file")
+	if !validFileName(qFile) {
+		sendErr(req.Context(), rw, logger, &api.Error{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("file must be a plain file name, got %q", qFile),
+		})
+		return
+	}
+	source, err := siblingSource(req.Context(), cfg, qFile)
+	if err != nil {
+		sendErr(req.Context(), rw, logger, err)
+		return
+	}
+
+	// the cache dir holds the live index, the file goes anywhere else
+	file, err := os.CreateTemp("", "speed-*")
+	if err != nil {
+		sendErr(req.Context(), rw, logger, err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	downloadCtx, cancel := context.WithTimeout(req.Context(), cfg.DownloadTimeout)
+	defer cancel()
+	err = source.Download(downloadCtx, file)
+	if errors.Is(err, ErrIndexNotFound) {
+		err = &api.Error{Status: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		sendErr(downloadCtx, rw, logger, fmt.Errorf("failed to download: %w", err))
+		return
+	}
+
+	resp := make(map[string]string)
+	resp["time"] = strconv.Itoa(int(time.Now().Sub(start).Milliseconds()))
+	send(rw, logger, http.StatusOK, resp)
+}
+
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// siblingSource reads the file called name from where the index archive of
+// cfg is published.
+func siblingSource(ctx context.Context, cfg *Config, name string) (IndexSource, error) {
+	sibling := *cfg
+	switch cfg.Source {
+	case sourceS3:
+		sibling.Key = path.Join(path.Dir(cfg.Key), name)
+	case sourceLocal:
+		sibling.LocalPath = filepath.Join(filepath.Dir(cfg.LocalPath), name)
+	case sourceHTTP:
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = path.Join(path.Dir(u.Path), name)
+		sibling.URL = u.String()
+	}
+	return newIndexSource(ctx, &sibling)
+}
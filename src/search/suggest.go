@@ -0,0 +1,69 @@
+package search
+
+import (
+	"context"
+	"github.com/nikolaymatrosov/go-sls-search/api"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// SuggestHandler completes a prefix typed by the user to titles and names
+// found in the index. It shares the warm index with SearchHandler, so it is
+// cheap enough to call on every keystroke.
+func SuggestHandler(rw http.ResponseWriter, req *http.Request) {
+	logger, _ := zap.NewProduction()
+	ctx, cancel := withBudget(context.WithValue(req.Context(), "durations", map[string]int64{}))
+	defer cancel()
+
+	resp, err := suggest(ctx, logger, req)
+	if err != nil {
+		sendErr(ctx, rw, logger, err)
+		return
+	}
+	send(rw, logger, http.StatusOK, resp)
+}
+
+func suggest(ctx context.Context, logger *zap.Logger, req *http.Request) (*api.SuggestResponse, error) {
+	suggestReq, err := api.ParseSuggest(req)
+	if err != nil {
+		return nil, requestErr(err)
+	}
+
+	durations := ctx.Value("durations").(map[string]int64)
+	t, err := openTarget(ctx, logger, req)
+	if err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	start := time.Now()
+	suggestions := api.NewSuggestions(suggestReq)
+	for _, field := range t.suggestFields() {
+		res, err := t.index.SearchInContext(ctx, suggestReq.SearchRequest(field))
+		if err != nil && ctx.Err() != nil {
+			return nil, outOfTime(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		suggestions.Add(field, res)
+	}
+	durations["suggest"] = time.Now().Sub(start).Microseconds()
+	return suggestions.Response(durations), nil
+}
+
+// suggestFields of all searched indexes.
+func (t *target) suggestFields() []string {
+	var fields []string
+	seen := map[string]bool{}
+	for _, idx := range t.served {
+		for _, f := range idx.cfg.SuggestFields {
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,233 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/nikolaymatrosov/go-sls-search/manifest"
+	"go.uber.org/zap"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every downloaded version of the index lives in its own directory next to
+// the active one and cfg.IndexPath() is a symlink to the version being
+// served:
+//
+//	/tmp/index -> index-3f1c...
+//	/tmp/index-3f1c.../index_meta.json
+//	/tmp/index-3f1c.../manifest.json
+//
+// A new version is extracted into a staging directory, renamed to its
+// version directory and only then the symlink is replaced, so the switch is
+// atomic for anyone opening the index by path.
+const (
+	versionDirPrefix = "index-"
+	stagingPrefix    = ".staging-"
+	linkPrefix       = ".index-"
+)
+
+// updateState serializes downloads of an index and remembers when its
+// manifest was last checked.
+type updateState struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+}
+
+// checkForUpdate compares the served index with the published manifest at
+// most once per cfg.ManifestTTL. Concurrent requests don't wait for the
+// check: whoever comes first does it and the rest keep serving the current
+// version. If the versions differ, install is returned to download the new
+// one and swap it in. The updates of the index stay locked until install
+// is done, so it must be called.
+func checkForUpdate(ctx context.Context, idx *searchIndex, l *zap.Logger) (install func(context.Context) error, err error) {
+	cfg := idx.cfg
+	if cfg.ManifestTTL <= 0 {
+		return nil, nil
+	}
+	if !idx.updates.mu.TryLock() {
+		return nil, nil
+	}
+	if time.Now().Sub(idx.updates.checkedAt) < cfg.ManifestTTL {
+		idx.updates.mu.Unlock()
+		return nil, nil
+	}
+	unlock, err := tryLockCacheDir(cfg)
+	if err != nil {
+		idx.updates.mu.Unlock()
+		if errors.Is(err, errLocked) {
+			// another process is installing a version right now
+			return nil, nil
+		}
+		return nil, err
+	}
+	release := func() {
+		unlock()
+		idx.updates.mu.Unlock()
+	}
+	defer func() {
+		if install == nil {
+			release()
+		}
+	}()
+
+	durations := ctx.Value("durations").(map[string]int64)
+	start := time.Now()
+	defer func() {
+		durations["checkForUpdate"] = time.Now().Sub(start).Microseconds()
+	}()
+
+	source, err := newIndexSource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := fetchManifest(ctx, cfg, source)
+	if err != nil {
+		return nil, err
+	}
+	idx.updates.checkedAt = time.Now()
+
+	local, err := manifest.ReadFile(path.Join(cfg.IndexPath(), manifest.FileName))
+	if err == nil && local.Version == remote.Version {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		l.Error("failed to read local manifest", zap.Error(err))
+	}
+
+	l.Info("new index version available",
+		zap.String("index", cfg.Name),
+		zap.String("version", remote.Version))
+	return func(ctx context.Context) error {
+		defer release()
+		removeStaging(cfg, l)
+		err := installIndex(ctx, cfg, source, remote, l)
+		if err != nil {
+			return err
+		}
+
+		// Reopening waits for the searches running against the old version.
+		// Once it is closed its files may go.
+		_, done, err := idx.warm.acquire(ctx, l, cfg.IndexPath())
+		if err != nil {
+			return err
+		}
+		done()
+		removeStaleVersions(cfg, remote, l)
+		return nil
+	}, nil
+}
+
+// installIndex downloads the version described by m and makes it active.
+func installIndex(ctx context.Context, cfg *Config, source IndexSource, m *manifest.Manifest, l *zap.Logger) error {
+	err := os.MkdirAll(cfg.CacheDir, 0755)
+	if err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(cfg.CacheDir, stagingPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	if m.SHA256 == "" {
+		l.Warn("index has no checksum, skipping verification", zap.String("version", m.Version))
+	}
+	err = downloadAndUnzip(ctx, cfg, source, m.SHA256, staging, l)
+	if err != nil {
+		return err
+	}
+
+	extracted := path.Join(staging, "index")
+	err = m.WriteFile(path.Join(extracted, manifest.FileName))
+	if err != nil {
+		return err
+	}
+
+	dir := versionDir(m)
+	target := path.Join(cfg.CacheDir, dir)
+	err = os.RemoveAll(target)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(extracted, target)
+	if err != nil {
+		return err
+	}
+	return activate(cfg, dir)
+}
+
+// activate atomically points cfg.IndexPath() to the version directory.
+func activate(cfg *Config, dir string) error {
+	indexPath := cfg.IndexPath()
+	info, err := os.Lstat(indexPath)
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		// index extracted by an older build without versions, rename
+		// can't replace a directory with a symlink
+		err = os.Rename(indexPath, path.Join(cfg.CacheDir, versionDirPrefix+"legacy"))
+		if err != nil {
+			return err
+		}
+	}
+
+	link := path.Join(cfg.CacheDir, fmt.Sprintf("%s%d.link", linkPrefix, time.Now().UnixNano()))
+	err = os.Symlink(dir, link)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(link, indexPath)
+	if err != nil {
+		os.Remove(link)
+		return err
+	}
+	return nil
+}
+
+func removeStaleVersions(cfg *Config, active *manifest.Manifest, l *zap.Logger) {
+	entries, err := os.ReadDir(cfg.CacheDir)
+	if err != nil {
+		l.Error("failed to list cache dir", zap.Error(err))
+		return
+	}
+	keep := versionDir(active)
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), versionDirPrefix) || e.Name() == keep {
+			continue
+		}
+		err := os.RemoveAll(path.Join(cfg.CacheDir, e.Name()))
+		if err != nil {
+			l.Error("failed to remove stale index", zap.String("dir", e.Name()), zap.Error(err))
+		}
+	}
+}
+
+// removeStaging cleans up after downloads interrupted by the container being
+// killed mid-request. Must be called with the updates lock of the index
+// held.
+func removeStaging(cfg *Config, l *zap.Logger) {
+	entries, err := os.ReadDir(cfg.CacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), stagingPrefix) && !strings.HasPrefix(e.Name(), linkPrefix) {
+			continue
+		}
+		err := os.RemoveAll(path.Join(cfg.CacheDir, e.Name()))
+		if err != nil {
+			l.Error("failed to remove partial download", zap.String("dir", e.Name()), zap.Error(err))
+		}
+	}
+}
+
+// versionDir derives a safe directory name from the version, which may be
+// an arbitrary string such as an ETag.
+func versionDir(m *manifest.Manifest) string {
+	sum := sha256.Sum256([]byte(m.Version))
+	return versionDirPrefix + hex.EncodeToString(sum[:8])
+}
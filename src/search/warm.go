@@ -0,0 +1,173 @@
+package search
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+	"go.uber.org/zap"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// warmIndex keeps the index open between invocations served by the same
+// container. The index is opened lazily on the first request, shared by
+// concurrent requests and reopened when the files on disk are replaced.
+type warmIndex struct {
+	// name is given to the open index, it tells which index a hit of an
+	// alias search came from.
+	name  string
+	mu    sync.RWMutex
+	index bleve.Index
+	meta  os.FileInfo
+
+	// ranges caches numeric field ranges of the open index for facets
+	// with automatic ranges.
+	rangesMu sync.Mutex
+	ranges   map[string]numericRange
+}
+
+type numericRange struct {
+	min, max float64
+	ok       bool
+}
+
+// acquire returns the open index. The index stays valid until release is
+// called, so callers must always call it once they are done searching.
+func (w *warmIndex) acquire(ctx context.Context, l *zap.Logger, indexPath string) (bleve.Index, func(), error) {
+	meta, err := os.Stat(path.Join(indexPath, "index_meta.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.mu.RLock()
+	if w.index != nil && sameFile(w.meta, meta) {
+		return w.index, w.mu.RUnlock, nil
+	}
+	w.mu.RUnlock()
+
+	w.mu.Lock()
+	if w.index == nil || !sameFile(w.meta, meta) {
+		err = w.reopen(ctx, l, indexPath, meta)
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.mu.RLock()
+	if w.index == nil {
+		w.mu.RUnlock()
+		return nil, nil, os.ErrClosed
+	}
+	return w.index, w.mu.RUnlock, nil
+}
+
+// reopen must be called with w.mu held for writing, which guarantees that
+// no search is running against the index being closed.
+func (w *warmIndex) reopen(ctx context.Context, l *zap.Logger, indexPath string, meta os.FileInfo) error {
+	if w.index != nil {
+		l.Info("index on disk was replaced, reopening")
+		err := w.index.Close()
+		if err != nil {
+			l.Error("error closing index", zap.Error(err))
+		}
+		w.index = nil
+	}
+
+	durations := ctx.Value("durations").(map[string]int64)
+	start := time.Now()
+	index, err := bleve.Open(indexPath)
+	if err != nil {
+		return err
+	}
+	durations["openIndex"] = time.Now().Sub(start).Microseconds()
+	index.SetName(w.name)
+
+	w.index = index
+	w.meta = meta
+	w.rangesMu.Lock()
+	w.ranges = nil
+	w.rangesMu.Unlock()
+	return nil
+}
+
+// fieldRange returns the smallest and the largest value of a numeric field
+// in the index. It must be called between acquire and release.
+func (w *warmIndex) fieldRange(index bleve.Index, field string) (float64, float64, bool, error) {
+	w.rangesMu.Lock()
+	r, ok := w.ranges[field]
+	w.rangesMu.Unlock()
+	if ok {
+		return r.min, r.max, r.ok, nil
+	}
+
+	min, minOk, err := fieldBound(index, field, field)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	max, maxOk, err := fieldBound(index, field, "-"+field)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	r = numericRange{min: min, max: max, ok: minOk && maxOk}
+
+	w.rangesMu.Lock()
+	if w.ranges == nil {
+		w.ranges = map[string]numericRange{}
+	}
+	w.ranges[field] = r
+	w.rangesMu.Unlock()
+	return r.min, r.max, r.ok, nil
+}
+
+// fieldBound returns the value of the field of the first document in the
+// given sort order. Documents without the field are sorted last.
+func fieldBound(index bleve.Index, field, sort string) (float64, bool, error) {
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 1, 0, false)
+	req.SortBy([]string{sort})
+	req.Fields = []string{field}
+	res, err := index.Search(req)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(res.Hits) == 0 {
+		return 0, false, nil
+	}
+	switch v := res.Hits[0].Fields[field].(type) {
+	case float64:
+		return v, true, nil
+	case []interface{}:
+		// multi-valued field, bleve sorts by the smallest value ascending
+		// and by the largest one descending
+		bound, found := 0.0, false
+		for _, item := range v {
+			f, ok := item.(float64)
+			if !ok {
+				continue
+			}
+			if !found || (sort == field && f < bound) || (sort != field && f > bound) {
+				bound, found = f, true
+			}
+		}
+		return bound, found, nil
+	}
+	return 0, false, nil
+}
+
+func (w *warmIndex) close(l *zap.Logger) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.index == nil {
+		return
+	}
+	err := w.index.Close()
+	if err != nil {
+		l.Error("error closing index", zap.Error(err))
+	}
+	w.index = nil
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
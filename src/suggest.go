@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/nikolaymatrosov/go-sls-search/search"
+	"net/http"
+)
+
+//goland:noinspection GoUnusedExportedFunction
+func SuggestHandler(rw http.ResponseWriter, req *http.Request) {
+	search.SuggestHandler(rw, req)
+}